@@ -0,0 +1,23 @@
+package config
+
+import "time"
+
+// Отслеживаемые поля карточки компании (CompanyChange.Field)
+const (
+	CompanyFieldAddress         = "address"
+	CompanyFieldRegion          = "region"
+	CompanyFieldOkved           = "okved"
+	CompanyFieldOkvedAdditional = "okvedAdditional"
+	CompanyFieldMSPCategory     = "mspCategory"
+	CompanyFieldRevenue         = "revenue"
+)
+
+// CompanyChange — изменение существенного поля карточки компании между двумя получениями данных ZCB
+type CompanyChange struct {
+	ID         int       `json:"id" gorm:"primaryKey"`
+	CompanyID  int       `json:"companyId" gorm:"index"`
+	Field      string    `json:"field"`
+	OldValue   string    `json:"oldValue"`
+	NewValue   string    `json:"newValue"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+const defaultCompanyReverifyPeriod = 7 * 24 * time.Hour
+
+// CompanyStatus — результат плановой перепроверки компании (Company). Eligible == false —
+// компания перестала соответствовать правилам, подбор грантов для неё закрыт
+type CompanyStatus struct {
+	ID              int        `json:"id" gorm:"primaryKey"`
+	CompanyID       int        `json:"companyId" gorm:"uniqueIndex"`
+	Eligible        bool       `json:"eligible"`
+	IneligibleSince *time.Time `json:"ineligibleSince"`
+	LastCheckID     int        `json:"lastCheckId"`
+	VerifiedAt      *time.Time `json:"verifiedAt"`
+	NextCheckAt     time.Time  `json:"nextCheckAt" gorm:"index"`
+}
+
+// CompanyReverifyPeriod — как часто перепроверять каждую компанию; задаётся COMPANY_REVERIFY_PERIOD
+// в формате time.ParseDuration
+func CompanyReverifyPeriod() time.Duration {
+	value, _ := os.LookupEnv("COMPANY_REVERIFY_PERIOD")
+	if period, err := time.ParseDuration(value); err == nil && period > 0 {
+		return period
+	}
+	return defaultCompanyReverifyPeriod
+}
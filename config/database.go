@@ -90,8 +90,97 @@ func AutoMigrate() error {
 		return errLogs
 	}
 
+	errJobs := DB().AutoMigrate(&Job{})
+	if errJobs != nil {
+		return errJobs
+	}
+
+	errUserSampleTransitions := DB().AutoMigrate(&UserSampleTransition{})
+	if errUserSampleTransitions != nil {
+		return errUserSampleTransitions
+	}
+
+	errRefreshTokens := DB().AutoMigrate(&RefreshToken{})
+	if errRefreshTokens != nil {
+		return errRefreshTokens
+	}
+
+	errRolePermissions := DB().AutoMigrate(&RolePermission{}, &SeededPermission{})
+	if errRolePermissions != nil {
+		return errRolePermissions
+	}
+
+	errModeratorRegions := DB().AutoMigrate(&ModeratorRegion{})
+	if errModeratorRegions != nil {
+		return errModeratorRegions
+	}
+
+	errEligibilityRules := DB().AutoMigrate(&EligibilityRule{}, &SeededEligibilityRules{})
+	if errEligibilityRules != nil {
+		return errEligibilityRules
+	}
+
+	errEligibilityChecks := DB().AutoMigrate(&EligibilityCheck{}, &EligibilityCheckResult{})
+	if errEligibilityChecks != nil {
+		return errEligibilityChecks
+	}
+
+	errCompanyDataCache := DB().AutoMigrate(&CompanyDataCache{})
+	if errCompanyDataCache != nil {
+		return errCompanyDataCache
+	}
+
+	errZCBUsage := DB().AutoMigrate(&ZCBUsage{})
+	if errZCBUsage != nil {
+		return errZCBUsage
+	}
+
+	errCompanyStatuses := DB().AutoMigrate(&CompanyStatus{})
+	if errCompanyStatuses != nil {
+		return errCompanyStatuses
+	}
+
+	errUserCompanies := DB().AutoMigrate(&UserCompany{}, &UserSampleCompany{})
+	if errUserCompanies != nil {
+		return errUserCompanies
+	}
+
+	errCompanyChanges := DB().AutoMigrate(&CompanyChange{})
+	if errCompanyChanges != nil {
+		return errCompanyChanges
+	}
+
+	errRateLimits := DB().AutoMigrate(&RateLimitCounter{}, &RateLimitAllowlist{})
+	if errRateLimits != nil {
+		return errRateLimits
+	}
+
+	errGrantTerms := DB().AutoMigrate(&GrantTerms{})
+	if errGrantTerms != nil {
+		return errGrantTerms
+	}
+
+	errGrantAlerts := DB().AutoMigrate(&GrantAlertSubscription{}, &Notification{})
+	if errGrantAlerts != nil {
+		return errGrantAlerts
+	}
+
+	errPlans := DB().AutoMigrate(&Plan{}, &PlanAssignment{}, &UsageCounter{}, &UserSampleCharge{})
+	if errPlans != nil {
+		return errPlans
+	}
+
+	errDecreeIndex := DB().AutoMigrate(&DecreeIndex{}, &GrantSearch{})
+	if errDecreeIndex != nil {
+		return errDecreeIndex
+	}
+
 	InitOkveds()
 	InitBlockedOkveds()
+	InitRolePermissions()
+	InitEligibilityRules()
+	InitUserCompanies()
+	InitPlans()
 
 	return nil
 }
@@ -0,0 +1,17 @@
+package config
+
+import "encoding/json"
+
+// DecreeIndex — место и ОКВЭД постановления (Decree), нормализованные так же, как при подборе грантов: по ним
+// подбор, каталог и доступ модераторов отбирают постановления в SQL. OkvedCodes — коды постановления,
+// OkvedPrefixes — они же вместе со всеми вышестоящими уровнями (62.01 → 62, 62.0, 62.01).
+// Version — версия нормализации, строки старой версии пересчитываются при запуске
+type DecreeIndex struct {
+	ID            int             `json:"id" gorm:"primaryKey"`
+	DecreeID      int             `json:"decreeId" gorm:"uniqueIndex"`
+	Region        string          `json:"region" gorm:"index"`
+	City          string          `json:"city" gorm:"index"`
+	OkvedCodes    json.RawMessage `json:"okvedCodes" gorm:"type:jsonb;index:idx_decree_indices_okved_codes,type:gin"`
+	OkvedPrefixes json.RawMessage `json:"okvedPrefixes" gorm:"type:jsonb;index:idx_decree_indices_okved_prefixes,type:gin"`
+	Version       int             `json:"version"`
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EligibilityCheck — проверка компании на соответствие правилам (CheckCompany).
+// Хранит данные ZCB, по которым она прошла, чтобы вердикт можно было объяснить и позже
+type EligibilityCheck struct {
+	ID        int             `json:"id" gorm:"primaryKey"`
+	INN       string          `json:"inn" gorm:"index"`
+	OGRN      string          `json:"ogrn"`
+	DecreeID  *int            `json:"decreeId"`
+	UserID    int             `json:"userId"`
+	Eligible  bool            `json:"eligible"`
+	Rejects   json.RawMessage `json:"rejects" gorm:"type:jsonb"`
+	CardData  json.RawMessage `json:"cardData,omitempty" gorm:"type:jsonb"`
+	FsspData  json.RawMessage `json:"fsspData,omitempty" gorm:"type:jsonb"`
+	FnsData   json.RawMessage `json:"fnsData,omitempty" gorm:"type:jsonb"`
+	CreatedAt time.Time       `json:"createdAt" gorm:"index"`
+
+	Results []EligibilityCheckResult `json:"results,omitempty" gorm:"foreignKey:CheckID"`
+}
+
+// EligibilityCheckResult — исход одного правила в проверке. Правило копируется целиком:
+// его могут изменить или удалить после проверки
+type EligibilityCheckResult struct {
+	ID       int             `json:"id" gorm:"primaryKey"`
+	CheckID  int             `json:"checkId" gorm:"index"`
+	RuleID   int             `json:"ruleId"`
+	Source   string          `json:"source"`
+	Path     string          `json:"path"`
+	Operator string          `json:"operator"`
+	Value    string          `json:"value"`
+	Match    string          `json:"match"`
+	WhenPath string          `json:"whenPath"`
+	Message  string          `json:"message"`
+	Applied  bool            `json:"applied"`
+	Passed   bool            `json:"passed"`
+	Values   json.RawMessage `json:"values" gorm:"type:jsonb"`
+	Rejects  json.RawMessage `json:"rejects" gorm:"type:jsonb"`
+}
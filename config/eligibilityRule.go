@@ -0,0 +1,117 @@
+package config
+
+import "time"
+
+// Источники данных компании, к которым применяется правило
+const (
+	EligibilitySourceCard = "card"
+	EligibilitySourceFssp = "fssp"
+	EligibilitySourceFns  = "fns"
+)
+
+// Операторы правил. Строковые сравнивают значение как строку, числовые — как число;
+// notBlockedOkved проверяет коды по справочнику BlockedOkveds
+const (
+	EligibilityOpEq              = "eq"
+	EligibilityOpNe              = "ne"
+	EligibilityOpIn              = "in"
+	EligibilityOpNotIn           = "notIn"
+	EligibilityOpGt              = "gt"
+	EligibilityOpGte             = "gte"
+	EligibilityOpLt              = "lt"
+	EligibilityOpLte             = "lte"
+	EligibilityOpNotBlockedOkved = "notBlockedOkved"
+)
+
+// Как сводятся значения по нескольким путям (и элементам массивов):
+// any — правило выполнено, если выполнено хоть для одного значения;
+// all — должно выполняться для каждого, на каждое нарушение — отдельный отказ
+const (
+	EligibilityMatchAny = "any"
+	EligibilityMatchAll = "all"
+)
+
+var EligibilityOperators = []string{
+	EligibilityOpEq, EligibilityOpNe, EligibilityOpIn, EligibilityOpNotIn,
+	EligibilityOpGt, EligibilityOpGte, EligibilityOpLt, EligibilityOpLte,
+	EligibilityOpNotBlockedOkved,
+}
+
+// EligibilityRule — условие, которому должна соответствовать компания. Path — jsonslice-пути
+// через ";" в данных источника Source; Value — порог или список через ";" для in/notIn.
+// WhenPath/WhenValue — правило применяется, только если значение по WhenPath входит в WhenValue.
+// В Message подстрока {value} заменяется нарушившим правило значением.
+// Правила без DecreeID действуют всегда, с DecreeID — дополнительно при проверке под постановление.
+type EligibilityRule struct {
+	ID        int    `json:"id" gorm:"primaryKey"`
+	DecreeID  *int   `json:"decreeId" gorm:"index"`
+	Source    string `json:"source"`
+	Path      string `json:"path"`
+	Operator  string `json:"operator"`
+	Value     string `json:"value"`
+	Match     string `json:"match"`
+	WhenPath  string `json:"whenPath"`
+	WhenValue string `json:"whenValue"`
+	Message   string `json:"message"`
+	Position  int    `json:"position"`
+	Disabled  bool   `json:"disabled"`
+}
+
+// Правила по умолчанию повторяют прежние проверки CheckCompany
+var defaultEligibilityRules = []EligibilityRule{
+	{Source: EligibilitySourceCard, Path: "$.body.docs.0.ТипДокумента", Operator: EligibilityOpIn, Value: "ul;ip",
+		Message: "Заявитель является физическим лицом."},
+	{Source: EligibilitySourceCard, Path: "$.body.docs.0.Реестр01;$.body.docs.0.Реестр02", Operator: EligibilityOpEq, Value: "0", Match: EligibilityMatchAny,
+		Message: "Юридическое лицо имеет взыскиваемую судебными приставами задолженность по уплате налогов, превышающую 1000 рублей или юридическое лицо не представляет налоговую отчетность более года."},
+	{Source: EligibilitySourceCard, Path: "$.body.docs.0.КатСубМСП.1", Operator: EligibilityOpIn, Value: "Микро;Малое;Среднее",
+		WhenPath: "$.body.docs.0.ТипДокумента", WhenValue: "ul",
+		Message: "Компания заявителя не находится в реестре МСП."},
+	{Source: EligibilitySourceCard, Path: "$.body.docs.0.КодОКВЭД;$.body.docs.0.СвОКВЭДДоп", Operator: EligibilityOpNotBlockedOkved, Match: EligibilityMatchAll,
+		Message: "Код деятельности компании попадает под ряд запрещенных к субсидированию. {value}"},
+	{Source: EligibilitySourceCard, Path: "$.body.docs.0.Активность", Operator: EligibilityOpEq, Value: "Действующее",
+		Message: "Компания ликвидирована или находится в процессе ликвидации, либо наложены другие ограничения на ведение деятельности."},
+	{Source: EligibilitySourceFssp, Path: "$.body.docs[*].ОстатокДолга", Operator: EligibilityOpLte, Value: "0", Match: EligibilityMatchAll,
+		Message: "Имеется непогашенный долг (по данным ФССП): {value}"},
+	{Source: EligibilitySourceFns, Path: "$.body.СвРеорг.СвСтатус.@attributes.КодСтатусЮЛ;$.body.СвСтатус.СвСтатус.@attributes.КодСтатусЮЛ", Operator: EligibilityOpNe, Value: "132", Match: EligibilityMatchAll,
+		Message: "Компания находится в процессе ликвидации."},
+}
+
+func IsEligibilityOperator(operator string) bool {
+	for _, op := range EligibilityOperators {
+		if op == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// SeededEligibilityRules — отметка, что правила по умолчанию уже заполнены. Если администратор удалит все
+// правила, после перезапуска они не появятся снова
+type SeededEligibilityRules struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// InitEligibilityRules заполняет правила по умолчанию один раз; дальше правилами управляет администратор.
+// Правила, которые уже есть в таблице, считаются заполненными: до SeededEligibilityRules их заполнял прежний запуск
+func InitEligibilityRules() {
+	var seeded int64
+	DB().Model(&SeededEligibilityRules{}).Count(&seeded)
+	if seeded > 0 {
+		return
+	}
+
+	var count int64
+	DB().Model(&EligibilityRule{}).Count(&count)
+	if count == 0 {
+		for i, rule := range defaultEligibilityRules {
+			rule.Position = i + 1
+			if rule.Match == "" {
+				rule.Match = EligibilityMatchAll
+			}
+			DB().Create(&rule)
+		}
+	}
+
+	DB().Create(&SeededEligibilityRules{})
+}
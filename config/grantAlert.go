@@ -0,0 +1,15 @@
+package config
+
+import "time"
+
+// GrantAlertSubscription — подписка пользователя на новые гранты, подходящие компании по ОКВЭД и региону.
+// Digest — одно письмо в день вместо письма на каждый грант; UnsubscribeToken — для ссылки отписки в письмах
+type GrantAlertSubscription struct {
+	ID               int        `json:"id" gorm:"primaryKey"`
+	UserID           int        `json:"userId" gorm:"uniqueIndex:idx_grant_alert_subscription"`
+	CompanyID        int        `json:"companyId" gorm:"uniqueIndex:idx_grant_alert_subscription"`
+	Digest           bool       `json:"digest"`
+	UnsubscribeToken string     `json:"-" gorm:"uniqueIndex"`
+	LastDigestAt     *time.Time `json:"lastDigestAt"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,9 @@
+package config
+
+// GrantSearch — текст гранта для поиска в каталоге: условия, строки гранта и его постановления, собранные
+// в tsvector. Вектор хранится и индексируется GIN, а не строится заново для каждой строки при поиске
+type GrantSearch struct {
+	ID       int    `json:"id" gorm:"primaryKey"`
+	GrantID  int    `json:"grantId" gorm:"uniqueIndex"`
+	Document string `json:"-" gorm:"type:tsvector;index:idx_grant_searches_document,type:gin"`
+}
@@ -0,0 +1,55 @@
+package config
+
+import "time"
+
+// Статусы гранта (GrantTerms.Status). Черновик и архив задаёт модератор; опубликованный грант планировщик
+// открывает в OpensAt и закрывает после Deadline
+const (
+	GrantStatusDraft     = "draft"
+	GrantStatusPublished = "published"
+	GrantStatusOpen      = "open"
+	GrantStatusClosed    = "closed"
+	GrantStatusArchived  = "archived"
+)
+
+var GrantStatuses = []string{GrantStatusDraft, GrantStatusPublished, GrantStatusOpen, GrantStatusClosed, GrantStatusArchived}
+
+func IsGrantStatus(status string) bool {
+	for _, s := range GrantStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantScheduled — статус меняется планировщиком по датам окна приёма заявок
+func GrantScheduled(status string) bool {
+	return status == GrantStatusPublished || status == GrantStatusOpen || status == GrantStatusClosed
+}
+
+// GrantTerms — условия гранта для каталога: название, описание, размер поддержки в рублях и окно приёма
+// заявок с OpensAt по Deadline. Грант без условий считается открытым, без суммы и срока
+type GrantTerms struct {
+	ID          int        `json:"id" gorm:"primaryKey"`
+	GrantID     int        `json:"grantId" gorm:"uniqueIndex"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	AmountMin   *int64     `json:"amountMin"`
+	AmountMax   *int64     `json:"amountMax"`
+	OpensAt     *time.Time `json:"opensAt"`
+	Deadline    *time.Time `json:"deadline" gorm:"index"`
+	Status      string     `json:"status" gorm:"index"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// ScheduledStatus — статус опубликованного гранта по окну приёма заявок в момент now
+func (t GrantTerms) ScheduledStatus(now time.Time) string {
+	if t.OpensAt != nil && now.Before(*t.OpensAt) {
+		return GrantStatusPublished
+	}
+	if t.Deadline != nil && now.After(*t.Deadline) {
+		return GrantStatusClosed
+	}
+	return GrantStatusOpen
+}
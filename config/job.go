@@ -0,0 +1,54 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job — задача фоновой очереди (ИИ, OCR, LibreOffice, ЗЧБ), переживающая перезапуск сервиса
+type Job struct {
+	ID          int             `json:"id" gorm:"primaryKey"`
+	Queue       string          `json:"queue" gorm:"index:idx_jobs_lease,priority:1"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"-" gorm:"type:jsonb"`
+	Result      []byte          `json:"-"`
+	Status      string          `json:"status" gorm:"index:idx_jobs_lease,priority:2"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt" gorm:"index:idx_jobs_lease,priority:3"`
+	LeasedUntil *time.Time      `json:"leasedUntil"`
+	Deadline    *time.Time      `json:"deadline"`
+	LastError   string          `json:"lastError"`
+
+	// Из готовых задач очереди первой берётся задача с большим приоритетом; фоновые — с отрицательным
+	Priority int `json:"priority"`
+
+	// Владелец задачи, если она относится к заявке пользователя
+	UserID   int `json:"userId" gorm:"index:idx_jobs_user_sample,priority:1"`
+	SampleID int `json:"sampleId" gorm:"index:idx_jobs_user_sample,priority:2"`
+
+	// Прогресс: текущий шаг и его позиция (страница N из M, чанк N из M)
+	Step        string `json:"step"`
+	StepCurrent int    `json:"stepCurrent"`
+	StepTotal   int    `json:"stepTotal"`
+	StepDetail  string `json:"stepDetail"`
+
+	FailureCode   string `json:"failureCode"`
+	FailureReason string `json:"failureReason"`
+
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+func (j Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Каталог внутри корня, где рядом с файлами хранится их Content-Type
+const localMetaDir = ".meta"
+
+// localStorage — реализация ObjectStorage в локальном каталоге (для разработки и интеграционных тестов)
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(root string) (*localStorage, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absRoot, 0o755); err != nil {
+		return nil, err
+	}
+	return &localStorage{root: absRoot}, nil
+}
+
+func (s *localStorage) objectPath(key string) (string, error) {
+	cleanKey := path.Clean("/" + key)
+	if cleanKey == "/" || strings.HasPrefix(cleanKey, "/"+localMetaDir+"/") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleanKey)), nil
+}
+
+func (s *localStorage) metaPath(key string) string {
+	return filepath.Join(s.root, localMetaDir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (s *localStorage) PutObject(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы читатели не видели недописанный объект
+	tmpFile, err := os.CreateTemp(filepath.Dir(objectPath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := io.Copy(tmpFile, reader); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), objectPath); err != nil {
+		return err
+	}
+
+	metaPath := s.metaPath(key)
+	if contentType == "" {
+		os.Remove(metaPath)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath, []byte(contentType), 0o644)
+}
+
+func (s *localStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+func (s *localStorage) ListObjects(ctx context.Context, prefix string, recursive bool) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	err := filepath.WalkDir(s.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filePath != s.root && entry.Name() == localMetaDir && filepath.Dir(filePath) == s.root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		// Без recursive, как и в MinIO, отдаём только объекты непосредственно под префиксом
+		if !recursive && strings.Contains(strings.TrimPrefix(key, prefix), "/") {
+			return nil
+		}
+
+		info, err := s.StatObject(ctx, key)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return objects, nil
+}
+
+func (s *localStorage) RemoveObject(ctx context.Context, key string) error {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	// Удаление несуществующего объекта не ошибка — так же ведёт себя MinIO
+	if err := os.Remove(objectPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.metaPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	stat, err := os.Stat(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, ErrObjectNotFound
+	} else if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if meta, err := os.ReadFile(s.metaPath(key)); err == nil {
+		contentType = string(meta)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return ObjectInfo{Key: key, Size: stat.Size(), ContentType: contentType}, nil
+}
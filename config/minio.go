@@ -1,6 +1,8 @@
 package config
 
 import (
+	"context"
+	"io"
 	"log"
 
 	"github.com/minio/minio-go/v7"
@@ -9,12 +11,13 @@ import (
 
 var minioClient *minio.Client
 
-var minioBucketName = getEnv("MINIO_BUCKET_NAME")
+var minioBucketName string
 
 func InitMinio() {
 	endpoint := getEnv("MINIO_ENDPOINT")
 	accessKeyID := getEnv("MINIO_ACCESSKEY_ID")
 	secretAccessKey := getEnv("MINIO_ACCESSKEY_SECRET")
+	minioBucketName = getEnv("MINIO_BUCKET_NAME")
 	useSSL := false
 
 	// Initialize minio client object.
@@ -39,3 +42,66 @@ func MinioClient() *minio.Client {
 func MinioBucketName() string {
 	return minioBucketName
 }
+
+// minioStorage — реализация ObjectStorage поверх MinIO
+type minioStorage struct {
+	client *minio.Client
+	bucket string
+}
+
+func (s *minioStorage) PutObject(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, reader, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *minioStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, minioError(err)
+	}
+
+	// GetObject ленивый — ошибку "нет такого ключа" MinIO отдаёт только при первом чтении
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, minioError(err)
+	}
+
+	return obj, nil
+}
+
+func (s *minioStorage) ListObjects(ctx context.Context, prefix string, recursive bool) ([]ObjectInfo, error) {
+	objectCh := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: recursive,
+	})
+
+	var objects []ObjectInfo
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, ObjectInfo{Key: object.Key, Size: object.Size, ContentType: object.ContentType})
+	}
+
+	return objects, nil
+}
+
+func (s *minioStorage) RemoveObject(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *minioStorage) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	stat, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, minioError(err)
+	}
+
+	return ObjectInfo{Key: stat.Key, Size: stat.Size, ContentType: stat.ContentType}, nil
+}
+
+func minioError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+// ModeratorRegion — субъект РФ, документами которого может управлять модератор (Decree.Region)
+type ModeratorRegion struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	UserID    int       `json:"userId" gorm:"uniqueIndex:idx_moderator_region"`
+	Region    string    `json:"region" gorm:"uniqueIndex:idx_moderator_region"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,23 @@
+package config
+
+import "time"
+
+// Виды уведомлений (Notification.Kind)
+const (
+	NotificationGrantPublished = "grantPublished"
+)
+
+// Notification — уведомление пользователя в приложении. Одно уведомление одного вида на грант и компанию;
+// EmailedAt — когда оно ушло письмом, сразу или в дайджесте
+type Notification struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"userId" gorm:"uniqueIndex:idx_notification"`
+	CompanyID int        `json:"companyId" gorm:"uniqueIndex:idx_notification"`
+	Kind      string     `json:"kind" gorm:"uniqueIndex:idx_notification"`
+	GrantID   int        `json:"grantId" gorm:"uniqueIndex:idx_notification"`
+	Title     string     `json:"title"`
+	Text      string     `json:"text"`
+	ReadAt    *time.Time `json:"readAt"`
+	EmailedAt *time.Time `json:"emailedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
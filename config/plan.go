@@ -0,0 +1,100 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Расходуемые ресурсы тарифа (UsageCounter.Metric)
+const (
+	UsageApplications = "applications"
+	UsageOCRPages     = "ocrPages"
+	UsageAIRequests   = "aiRequests"
+)
+
+var UsageMetrics = []string{UsageApplications, UsageOCRPages, UsageAIRequests}
+
+const defaultPlanCode = "free"
+
+// Plan — тариф: сколько заявок, страниц OCR и запросов к YandexGPT компания может расходовать в месяц.
+// nil — без ограничения
+type Plan struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	Code         string    `json:"code" gorm:"uniqueIndex"`
+	Name         string    `json:"name"`
+	Applications *int      `json:"applications"`
+	OCRPages     *int      `json:"ocrPages"`
+	AIRequests   *int      `json:"aiRequests"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Limit — месячный лимит ресурса metric; nil — без ограничения
+func (p Plan) Limit(metric string) *int {
+	switch metric {
+	case UsageApplications:
+		return p.Applications
+	case UsageOCRPages:
+		return p.OCRPages
+	case UsageAIRequests:
+		return p.AIRequests
+	}
+	return nil
+}
+
+// PlanAssignment — тариф компании до ExpiresAt (nil — бессрочно); без назначения действует DefaultPlanCode
+type PlanAssignment struct {
+	ID         int        `json:"id" gorm:"primaryKey"`
+	CompanyID  int        `json:"companyId" gorm:"uniqueIndex"`
+	PlanID     int        `json:"planId"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	AssignedBy int        `json:"assignedBy"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// UsageCounter — расход ресурса компанией от имени пользователя за месяц Period (UsagePeriod)
+type UsageCounter struct {
+	ID        int    `json:"id" gorm:"primaryKey"`
+	CompanyID int    `json:"companyId" gorm:"uniqueIndex:idx_usage_counter"`
+	UserID    int    `json:"userId" gorm:"uniqueIndex:idx_usage_counter"`
+	Metric    string `json:"metric" gorm:"uniqueIndex:idx_usage_counter"`
+	Period    string `json:"period" gorm:"uniqueIndex:idx_usage_counter"`
+	Count     int    `json:"count"`
+}
+
+// UserSampleCharge — заявка, за которую компании уже списана единица UsageApplications;
+// заявка оплачивается один раз, сколько бы раз её ни обрабатывали повторно
+type UserSampleCharge struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	UserSampleID int       `json:"userSampleId" gorm:"uniqueIndex"`
+	CompanyID    int       `json:"companyId"`
+	UserID       int       `json:"userId"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func UsagePeriod(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// DefaultPlanCode — тариф компаний без назначенного; задаётся DEFAULT_PLAN, по умолчанию free
+func DefaultPlanCode() string {
+	if code, ok := os.LookupEnv("DEFAULT_PLAN"); ok && code != "" {
+		return code
+	}
+	return defaultPlanCode
+}
+
+func intPtr(value int) *int {
+	return &value
+}
+
+// InitPlans создаёт бесплатный тариф, если его нет; дальше тарифами управляет администратор
+func InitPlans() {
+	free := Plan{
+		Code:         defaultPlanCode,
+		Name:         "Бесплатный",
+		Applications: intPtr(3),
+		OCRPages:     intPtr(100),
+		AIRequests:   intPtr(30),
+	}
+	DB().Where(Plan{Code: free.Code}).FirstOrCreate(&free)
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ограничиваемые маршруты без авторизации
+const (
+	RateLimitCheckCompany  = "checkCompany"
+	RateLimitFindGrantAnon = "findGrantAnon"
+)
+
+var RateLimitEndpoints = []string{RateLimitCheckCompany, RateLimitFindGrantAnon}
+
+// RateLimit — не больше Limit запросов за скользящее окно Window
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+var defaultRateLimits = map[string]RateLimit{
+	RateLimitCheckCompany:  {Limit: 10, Window: 24 * time.Hour},
+	RateLimitFindGrantAnon: {Limit: 10, Window: 24 * time.Hour},
+}
+
+var rateLimitEnv = map[string]string{
+	RateLimitCheckCompany:  "RATE_LIMIT_CHECK_COMPANY",
+	RateLimitFindGrantAnon: "RATE_LIMIT_FIND_GRANT_ANON",
+}
+
+// RateLimitCounter — число запросов subject к endpoint в окне, начавшемся в WindowStart
+type RateLimitCounter struct {
+	ID          int       `json:"id" gorm:"primaryKey"`
+	Endpoint    string    `json:"endpoint" gorm:"uniqueIndex:idx_rate_limit_counter"`
+	Subject     string    `json:"subject" gorm:"uniqueIndex:idx_rate_limit_counter"`
+	WindowStart time.Time `json:"windowStart" gorm:"uniqueIndex:idx_rate_limit_counter;index"`
+	Count       int       `json:"count"`
+}
+
+// RateLimitAllowlist — адрес или подсеть (CIDR) партнёра, на которые ограничения не действуют
+type RateLimitAllowlist struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	Address   string    `json:"address" gorm:"uniqueIndex"`
+	Note      string    `json:"note"`
+	CreatedBy int       `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RateLimitFor — ограничение endpoint. Задаётся RATE_LIMIT_CHECK_COMPANY и RATE_LIMIT_FIND_GRANT_ANON
+// в формате <число>/<окно>, например 10/24h; 0 в числе снимает ограничение
+func RateLimitFor(endpoint string) RateLimit {
+	value, _ := os.LookupEnv(rateLimitEnv[endpoint])
+	if limit, window, ok := strings.Cut(value, "/"); ok {
+		count, errCount := strconv.Atoi(strings.TrimSpace(limit))
+		duration, errWindow := time.ParseDuration(strings.TrimSpace(window))
+		if errCount == nil && errWindow == nil && count >= 0 && duration > 0 {
+			return RateLimit{Limit: count, Window: duration}
+		}
+	}
+	return defaultRateLimits[endpoint]
+}
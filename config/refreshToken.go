@@ -0,0 +1,20 @@
+package config
+
+import "time"
+
+// Срок жизни accessToken, выданного вместе с refreshToken, и самого refreshToken (в днях)
+const SessionAccessTokenLifetime = 15 * time.Minute
+const RefreshTokenValidality = 30
+
+// RefreshToken — токен обновления сессии. Хранится только хеш. Token.ValidTrough — дата без времени,
+// поэтому точный срок жизни парного accessToken хранится здесь же (AccessExpiresAt).
+type RefreshToken struct {
+	ID              int        `json:"id" gorm:"primaryKey"`
+	TokenHash       string     `json:"-" gorm:"uniqueIndex"`
+	UserID          int        `json:"userId" gorm:"index"`
+	AccessTokenID   int        `json:"-" gorm:"index"`
+	AccessExpiresAt time.Time  `json:"accessExpiresAt"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+	RevokedAt       *time.Time `json:"revokedAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
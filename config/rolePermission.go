@@ -0,0 +1,118 @@
+package config
+
+import "time"
+
+const (
+	PermDecreeRead  = "decree:read"
+	PermDecreeWrite = "decree:write"
+	PermGrantRead   = "grant:read"
+	PermGrantWrite  = "grant:write"
+	PermSampleRead  = "sample:read"
+	PermSampleWrite = "sample:write"
+	PermCompanyList = "company:list"
+	PermLogsRead    = "logs:read"
+	// PermCatalogAll — подбор показывает все последние документы, а не только подходящие компании
+	PermCatalogAll        = "catalog:all"
+	PermUserSampleRead    = "userSample:read"
+	PermUserSampleRewind  = "userSample:rewind"
+	PermSessionRevoke     = "session:revoke"
+	PermPermissionsManage = "permission:manage"
+	// PermRegionAll — доступ к документам всех регионов; без него действуют регионы из ModeratorRegion
+	PermRegionAll         = "region:all"
+	PermModeratorsManage  = "moderator:manage"
+	PermEligibilityManage = "eligibility:manage"
+	// PermEligibilityChecksRead — история проверок любых компаний; свою компания видит без него
+	PermEligibilityChecksRead = "eligibilityCheck:read"
+	// PermCompanyRefresh — запрос данных ZCB в обход кеша
+	PermCompanyRefresh = "company:refresh"
+	PermZCBUsageRead   = "zcb:usage"
+	// PermUserCompaniesManage — связывать пользователей с любыми компаниями; владелец управляет только своей
+	PermUserCompaniesManage = "userCompany:manage"
+	// PermCompanyChangesRead — изменения карточек любых компаний; свои компании видны и без него
+	PermCompanyChangesRead = "companyChange:read"
+	// PermRateLimitManage — адреса партнёров, на которые не действуют ограничения запросов
+	PermRateLimitManage = "rateLimit:manage"
+	// PermPlansManage — тарифы и их назначение компаниям
+	PermPlansManage = "plan:manage"
+	// PermQuotaUnlimited — расход не ограничен тарифом компании
+	PermQuotaUnlimited = "quota:unlimited"
+)
+
+var Permissions = []string{
+	PermDecreeRead, PermDecreeWrite,
+	PermGrantRead, PermGrantWrite,
+	PermSampleRead, PermSampleWrite,
+	PermCompanyList, PermLogsRead, PermCatalogAll,
+	PermUserSampleRead, PermUserSampleRewind,
+	PermSessionRevoke, PermPermissionsManage,
+	PermRegionAll, PermModeratorsManage,
+	PermEligibilityManage, PermEligibilityChecksRead,
+	PermCompanyRefresh, PermZCBUsageRead,
+	PermUserCompaniesManage, PermCompanyChangesRead,
+	PermRateLimitManage,
+	PermPlansManage, PermQuotaUnlimited,
+}
+
+// Права ролей по умолчанию — совпадают с прежними проверками в контроллерах
+var defaultRolePermissions = map[string][]string{
+	"admin": Permissions,
+	"moderator": {
+		PermDecreeRead, PermDecreeWrite,
+		PermGrantRead, PermGrantWrite,
+		PermSampleRead, PermSampleWrite,
+		PermUserSampleRead,
+		PermCompanyChangesRead,
+	},
+	"tester": {PermCatalogAll, PermQuotaUnlimited},
+}
+
+// RolePermission — право permission, выданное роли role (User.Role)
+type RolePermission struct {
+	ID         int    `json:"id" gorm:"primaryKey"`
+	Role       string `json:"role" gorm:"uniqueIndex:idx_role_permission"`
+	Permission string `json:"permission" gorm:"uniqueIndex:idx_role_permission"`
+}
+
+func IsPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// SeededPermission — право, для которого роли уже получили права по умолчанию. Отозванное администратором
+// у всех ролей право остаётся отозванным после перезапуска
+type SeededPermission struct {
+	ID         int       `json:"id" gorm:"primaryKey"`
+	Permission string    `json:"permission" gorm:"uniqueIndex"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// InitRolePermissions выдаёт права по умолчанию один раз для каждого права — так новые права появляются
+// у ролей при обновлении, а изменения администратора сохраняются. Права, которые уже есть у ролей,
+// считаются выданными: до SeededPermission их выдавал прежний запуск
+func InitRolePermissions() {
+	for _, permission := range Permissions {
+		var seeded int64
+		DB().Model(&SeededPermission{}).Where(SeededPermission{Permission: permission}).Count(&seeded)
+		if seeded > 0 {
+			continue
+		}
+
+		var count int64
+		DB().Model(&RolePermission{}).Where(RolePermission{Permission: permission}).Count(&count)
+		if count == 0 {
+			for role, permissions := range defaultRolePermissions {
+				for _, p := range permissions {
+					if p == permission {
+						DB().Create(&RolePermission{Role: role, Permission: permission})
+					}
+				}
+			}
+		}
+
+		DB().Create(&SeededPermission{Permission: permission})
+	}
+}
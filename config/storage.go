@@ -0,0 +1,51 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+}
+
+// ObjectStorage — хранилище файлов, через которое работают все контроллеры
+type ObjectStorage interface {
+	PutObject(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	ListObjects(ctx context.Context, prefix string, recursive bool) ([]ObjectInfo, error)
+	RemoveObject(ctx context.Context, key string) error
+	StatObject(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+var storage ObjectStorage
+
+// InitStorage выбирает реализацию хранилища по STORAGE_BACKEND (minio по умолчанию, local — каталог LOCAL_STORAGE_PATH)
+func InitStorage() {
+	backend, _ := os.LookupEnv("STORAGE_BACKEND")
+
+	switch backend {
+	case "", "minio":
+		InitMinio()
+		storage = &minioStorage{client: minioClient, bucket: minioBucketName}
+	case "local":
+		localStorage, err := newLocalStorage(getEnv("LOCAL_STORAGE_PATH"))
+		if err != nil {
+			panic(err)
+		}
+		storage = localStorage
+	default:
+		panic(fmt.Sprintf("Unknown storage backend %s", backend))
+	}
+}
+
+func Storage() ObjectStorage {
+	return storage
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// Роль пользователя в компании (UserCompany.Role)
+const (
+	UserCompanyOwner      = "owner"
+	UserCompanyConsultant = "consultant"
+)
+
+func IsUserCompanyRole(role string) bool {
+	return role == UserCompanyOwner || role == UserCompanyConsultant
+}
+
+// UserCompany — компания, от имени которой пользователь может работать. Владелец (owner) представляет
+// саму компанию и управляет доступом к ней; консультант (consultant) готовит заявки для клиента
+type UserCompany struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	UserID    int       `json:"userId" gorm:"uniqueIndex:idx_user_company"`
+	CompanyID int       `json:"companyId" gorm:"uniqueIndex:idx_user_company;index"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserSampleCompany — компания, для которой заполняется заявка (UserSample); у консультанта по одному
+// образцу может быть несколько заявок — по одной на клиента
+type UserSampleCompany struct {
+	ID           int `json:"id" gorm:"primaryKey"`
+	UserSampleID int `json:"userSampleId" gorm:"uniqueIndex"`
+	CompanyID    int `json:"companyId" gorm:"index"`
+}
+
+// InitUserCompanies делает пользователей владельцами компаний из User.CompanyINN, если связи ещё нет
+func InitUserCompanies() {
+	var users []User
+	DB().Where("company_inn <> '' AND id NOT IN (?)", DB().Model(&UserCompany{}).Select("user_id")).Find(&users)
+
+	for _, user := range users {
+		var company Company
+		res := DB().Where(Company{INN: user.CompanyINN}).Limit(1).Find(&company)
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		link := UserCompany{UserID: user.ID, CompanyID: company.ID, Role: UserCompanyOwner}
+		DB().Clauses(clause.OnConflict{DoNothing: true}).Create(&link)
+	}
+}
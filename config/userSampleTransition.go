@@ -0,0 +1,15 @@
+package config
+
+import "time"
+
+// UserSampleTransition — запись истории смены статуса заявки (UserSample)
+type UserSampleTransition struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	UserSampleID int       `json:"userSampleId" gorm:"index"`
+	FromStatus   string    `json:"from"`
+	ToStatus     string    `json:"to"`
+	ActorID      int       `json:"actorId"`
+	Reason       string    `json:"reason"`
+	Rewind       bool      `json:"rewind"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
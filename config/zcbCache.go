@@ -0,0 +1,49 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// Запросы к ZCB, ответы на которые кешируются в CompanyDataCache и Company.CardData, FsspData и FnsData
+const (
+	ZCBEndpointCard = "card"
+	ZCBEndpointFssp = "fssp"
+	ZCBEndpointFns  = "fns"
+)
+
+var defaultZCBCacheTTL = map[string]time.Duration{
+	ZCBEndpointCard: 7 * 24 * time.Hour,
+	ZCBEndpointFssp: 24 * time.Hour,
+	ZCBEndpointFns:  7 * 24 * time.Hour,
+}
+
+// CompanyDataCache — последний ответ ZCB на запрос endpoint по ИНН. Хранится для любой проверенной компании,
+// в том числе не прошедшей правила или ещё не зарегистрированной, — повторная проверка в пределах TTL бесплатна
+type CompanyDataCache struct {
+	ID        int             `json:"id" gorm:"primaryKey"`
+	INN       string          `json:"inn" gorm:"uniqueIndex:idx_company_data_cache"`
+	Endpoint  string          `json:"endpoint" gorm:"uniqueIndex:idx_company_data_cache"`
+	Data      json.RawMessage `json:"data" gorm:"type:jsonb"`
+	FetchedAt time.Time       `json:"fetchedAt"`
+}
+
+// ZCBUsage — число платных запросов к ZCB за день
+type ZCBUsage struct {
+	ID       int    `json:"id" gorm:"primaryKey"`
+	Day      string `json:"day" gorm:"uniqueIndex:idx_zcb_usage"`
+	Endpoint string `json:"endpoint" gorm:"uniqueIndex:idx_zcb_usage"`
+	Calls    int    `json:"calls"`
+}
+
+// ZCBCacheTTL — срок свежести данных запроса endpoint. Задаётся ZCB_CARD_TTL, ZCB_FSSP_TTL и ZCB_FNS_TTL
+// в формате time.ParseDuration (например 72h); 0 отключает кеш
+func ZCBCacheTTL(endpoint string) time.Duration {
+	value, _ := os.LookupEnv("ZCB_" + strings.ToUpper(endpoint) + "_TTL")
+	if ttl, err := time.ParseDuration(value); err == nil {
+		return ttl
+	}
+	return defaultZCBCacheTTL[endpoint]
+}
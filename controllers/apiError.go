@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+// APIError — ошибка API со стабильным машинным кодом. Клиенту отдаётся как
+// {"error": код, "message": сообщение на языке из Accept-Language, "details": детали}.
+// Cause в ответ не попадает — только в лог.
+type APIError struct {
+	Status  int
+	Code    string
+	Details interface{}
+	Cause   error
+}
+
+func NewAPIError(status int, code string) *APIError {
+	return &APIError{Status: status, Code: code}
+}
+
+func (e *APIError) WithDetails(details interface{}) *APIError {
+	e.Details = details
+	return e
+}
+
+func (e *APIError) WithCause(err error) *APIError {
+	e.Cause = err
+	return e
+}
+
+func (e *APIError) Error() string {
+	if e.Cause != nil {
+		return e.Code + ": " + e.Cause.Error()
+	}
+	return e.Code
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Cause
+}
+
+// wrapError превращает ошибку в APIError: ошибки этапов и проверки полей сохраняют свой код,
+// остальные получают code и status
+func wrapError(status int, code string, err error) *APIError {
+	var apiErr *APIError
+	var workflowErr *WorkflowError
+	var validationErr *ValidationError
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &workflowErr):
+		return NewAPIError(status, "invalid_step").WithDetails(workflowErr)
+	case errors.As(err, &validationErr):
+		return NewAPIError(http.StatusBadRequest, "validation_failed").WithDetails(validationErr)
+	}
+	return NewAPIError(status, code).WithCause(err)
+}
+
+type errorMessage struct {
+	ru string
+	en string
+}
+
+var errorMessages = map[string]errorMessage{
+	"unauthorized":             {"Требуется авторизация", "Authorization required"},
+	"access_denied":            {"Недостаточно прав", "Insufficient permissions"},
+	"user_suspended":           {"Пользователь заблокирован", "User is suspended"},
+	"region_forbidden":         {"Нет доступа к документам этого региона", "No access to documents of this region"},
+	"session_invalid":          {"Сессия не найдена", "Session not found"},
+	"session_revoked":          {"Сессия отозвана", "Session has been revoked"},
+	"session_expired":          {"Сессия истекла", "Session has expired"},
+	"field_missing":            {"Не заполнено обязательное поле", "Required field is missing"},
+	"invalid_params":           {"Некорректные параметры", "Invalid parameters"},
+	"validation_failed":        {"Ошибка проверки полей", "Field validation failed"},
+	"invalid_json":             {"Некорректный JSON в теле запроса", "Malformed JSON in request body"},
+	"invalid_step":             {"Действие недоступно на текущем этапе заявки", "Action is not allowed at the current application step"},
+	"awaiting_ai":              {"Идёт обработка документов ИИ", "AI processing is in progress"},
+	"not_found":                {"Объект не найден", "Object not found"},
+	"user_not_found":           {"Пользователь не найден", "User not found"},
+	"company_data_unavailable": {"Сервис данных о компаниях недоступен, повторите проверку позже", "Company data service is unavailable, try again later"},
+	"company_not_found":        {"Компания не найдена", "Company not found"},
+	"company_required":         {"Выберите компанию в заголовке companyINN", "Select a company with the companyINN header"},
+	"company_access_denied":    {"Нет доступа к этой компании", "No access to this company"},
+	"primary_company":          {"Основную компанию пользователя отвязать нельзя", "User's primary company cannot be unlinked"},
+	"user_sample_not_found":    {"Заявка не найдена", "Application not found"},
+	"job_not_found":            {"Обработка не запускалась", "Processing has not been started"},
+	"files_not_found":          {"Файлы не найдены", "No files found"},
+	"company_not_eligible":     {"Компания не соответствует требованиям", "Company does not meet the requirements"},
+	"grant_not_open":           {"Грант сейчас не принимает заявки", "Grant is not accepting applications"},
+	"grant_status_transition":  {"Недопустимая смена статуса гранта", "Grant status change is not allowed"},
+	"grant_deadline_passed":    {"Срок подачи заявок истёк, сначала продлите его", "Application deadline has passed, extend it first"},
+	"document_locked":          {"Изменение обязательного документа запрещено", "Required document cannot be changed"},
+	"file_required":            {"Файл обязателен", "File is required"},
+	"invalid_file_type":        {"Недопустимый тип файла", "File type is not allowed"},
+	"protected_permission":     {"Нельзя отозвать управление правами у администратора", "Permission management cannot be revoked from admin"},
+	"quota_exceeded":           {"Исчерпан лимит тарифа на этот месяц", "Plan quota for this month is exhausted"},
+	"rate_limited":             {"Превышен лимит запросов", "Too many requests"},
+	"storage_error":            {"Ошибка файлового хранилища", "File storage error"},
+	"database_error":           {"Ошибка базы данных", "Database error"},
+	"queue_error":              {"Не удалось поставить обработку в очередь", "Failed to enqueue processing"},
+	"fill_failed":              {"Ошибка заполнения документов", "Failed to fill documents"},
+	"archive_error":            {"Ошибка формирования архива", "Failed to build archive"},
+	"mail_failed":              {"Не удалось отправить письмо", "Failed to send email"},
+	"method_not_allowed":       {"Метод не поддерживается", "Method not allowed"},
+	"payload_too_large":        {"Слишком большой запрос", "Request is too large"},
+	"internal_error":           {"Внутренняя ошибка сервера", "Internal server error"},
+}
+
+func errorLanguage(c echo.Context) string {
+	if strings.HasPrefix(strings.ToLower(c.Request().Header.Get("Accept-Language")), "en") {
+		return "en"
+	}
+	return "ru"
+}
+
+func localizedMessage(code string, language string) string {
+	message, ok := errorMessages[code]
+	if !ok {
+		message = errorMessages["internal_error"]
+	}
+	if language == "en" {
+		return message.en
+	}
+	return message.ru
+}
+
+// codeForStatus — код для ошибок echo (нет маршрута, неверный метод и т.п.)
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_params"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "access_denied"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusRequestEntityTooLarge:
+		return "payload_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	}
+	return "internal_error"
+}
+
+// HTTPErrorHandler — центральный обработчик ошибок, возвращённых обработчиками и middleware
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var apiErr *APIError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		apiErr = NewAPIError(httpErr.Code, codeForStatus(httpErr.Code))
+	default:
+		apiErr = wrapError(http.StatusInternalServerError, "internal_error", err)
+	}
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+
+	body := map[string]interface{}{
+		"error":   apiErr.Code,
+		"message": localizedMessage(apiErr.Code, errorLanguage(c)),
+	}
+	if apiErr.Details != nil {
+		body["details"] = apiErr.Details
+	}
+
+	var sendErr error
+	if c.Request().Method == http.MethodHead {
+		sendErr = c.NoContent(apiErr.Status)
+	} else {
+		sendErr = c.JSON(apiErr.Status, body)
+	}
+	if sendErr != nil {
+		c.Logger().Error(sendErr)
+	}
+}
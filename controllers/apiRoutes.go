@@ -0,0 +1,530 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"park/config"
+	"strings"
+	"sync"
+)
+
+const apiV1Prefix = "/api/v1"
+
+type apiParam struct {
+	Name        string
+	Required    bool
+	Description string
+}
+
+// apiBody — тело запроса: сущность (JSON или часть multipart) и/или файлы; JSONOnly — только JSON-тело
+type apiBody struct {
+	Entity   string
+	Files    []string
+	JSONOnly bool
+}
+
+// apiRoute — описание маршрута /api/v1. Из этой же таблицы регистрируются маршруты и строится OpenAPI,
+// поэтому документация не расходится с реальными обработчиками.
+type apiRoute struct {
+	Method     string
+	Path       string
+	Handler    echo.HandlerFunc
+	Tag        string
+	Summary    string
+	Public     bool
+	Permission string
+	Headers    []apiParam
+	Query      []apiParam
+	Body       *apiBody
+}
+
+func requiredHeader(name string, description string) apiParam {
+	return apiParam{Name: name, Required: true, Description: description}
+}
+
+func optionalHeader(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description}
+}
+
+// Параметры строки запроса — для русского текста: браузер не отправит его в заголовке
+func requiredQuery(name string, description string) apiParam {
+	return apiParam{Name: name, Required: true, Description: description}
+}
+
+func optionalQuery(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description}
+}
+
+var apiV1Routes = []apiRoute{
+	// Сессии
+	{Method: http.MethodPost, Path: "/createSession", Handler: CreateSession, Tag: "sessions",
+		Summary: "Обменять accessToken входа на пару короткий accessToken + refreshToken"},
+	{Method: http.MethodPost, Path: "/refreshSession", Handler: RefreshSession, Tag: "sessions", Public: true,
+		Summary: "Обновить пару токенов", Headers: []apiParam{requiredHeader("refreshToken", "Текущий refreshToken")}},
+	{Method: http.MethodPost, Path: "/logout", Handler: Logout, Tag: "sessions",
+		Summary: "Завершить текущую сессию"},
+	{Method: http.MethodPost, Path: "/logoutAll", Handler: LogoutAll, Tag: "sessions",
+		Summary: "Завершить все сессии пользователя", Headers: []apiParam{optionalHeader("userId", "Чужой пользователь (право session:revoke)")}},
+
+	// Компании
+	{Method: http.MethodGet, Path: "/getCompany", Handler: GetCompany, Tag: "companies",
+		Summary: "Компания текущего пользователя", Headers: []apiParam{activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/listUserCompanies", Handler: ListUserCompanies, Tag: "companies",
+		Summary: "Компании пользователя и его роль в каждой",
+		Headers: []apiParam{optionalHeader("userId", "Чужой пользователь (право userCompany:manage)")}},
+	{Method: http.MethodPost, Path: "/linkUserCompany", Handler: LinkUserCompany, Tag: "companies",
+		Summary: "Дать пользователю доступ к компании (владелец компании или право userCompany:manage)",
+		Headers: []apiParam{
+			requiredHeader("companyINN", "ИНН компании"),
+			requiredHeader("email", "Почта пользователя"),
+			optionalHeader("role", "owner или consultant (по умолчанию)"),
+		}},
+	{Method: http.MethodPost, Path: "/unlinkUserCompany", Handler: UnlinkUserCompany, Tag: "companies",
+		Summary: "Отозвать доступ к компании",
+		Headers: []apiParam{requiredHeader("companyINN", "ИНН компании"), optionalHeader("userId", "Чужой пользователь; без него — отказаться от доступа самому")}},
+	{Method: http.MethodGet, Path: "/listCompanies", Handler: ListCompanies, Tag: "companies", Permission: config.PermCompanyList,
+		Summary: "Список компаний"},
+	{Method: http.MethodGet, Path: "/listCompanyStatuses", Handler: ListCompanyStatuses, Tag: "companies", Permission: config.PermCompanyList,
+		Summary: "Результаты плановой перепроверки компаний", Headers: []apiParam{optionalHeader("ineligible", "true — только переставшие соответствовать правилам")}},
+	{Method: http.MethodGet, Path: "/checkCompany", Handler: CheckCompany, Tag: "companies", Public: true,
+		Summary: "Проверить компанию по ИНН",
+		Headers: []apiParam{
+			requiredHeader("inn", "ИНН компании"),
+			optionalHeader("decreeId", "Проверить и по правилам постановления"),
+			optionalHeader("refresh", "true — запросить данные ZCB в обход кеша (право company:refresh)"),
+		}},
+	{Method: http.MethodGet, Path: "/listCompanyChanges", Handler: ListCompanyChanges, Tag: "companies",
+		Summary: "Изменения адреса, ОКВЭД, категории МСП и выручки компании между проверками",
+		Headers: []apiParam{
+			requiredHeader("inn", "ИНН связанной компании или чужой (право companyChange:read)"),
+			optionalHeader("since", "Только изменения с момента в RFC 3339"),
+		}},
+	{Method: http.MethodGet, Path: "/listEligibilityChecks", Handler: ListEligibilityChecks, Tag: "companies",
+		Summary: "История проверок компании",
+		Headers: []apiParam{optionalHeader("inn", "ИНН связанной компании или чужой (право eligibilityCheck:read); по умолчанию — активная компания"), activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/getEligibilityCheck", Handler: GetEligibilityCheck, Tag: "companies",
+		Summary: "Проверка компании с исходом каждого правила и данными ZCB", Headers: []apiParam{requiredHeader("checkId", "ID проверки")}},
+
+	// Тарифы
+	{Method: http.MethodGet, Path: "/getQuota", Handler: GetQuota, Tag: "plans",
+		Summary: "Тариф компании и остаток заявок, страниц OCR и запросов к ИИ на текущий месяц", Headers: []apiParam{activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/listPlans", Handler: ListPlans, Tag: "plans",
+		Summary: "Тарифы"},
+	{Method: http.MethodPost, Path: "/savePlan", Handler: SavePlan, Tag: "plans", Permission: config.PermPlansManage,
+		Summary: "Создать тариф или изменить тариф с тем же кодом", Body: &apiBody{Entity: "plan", JSONOnly: true}},
+	{Method: http.MethodPost, Path: "/assignPlan", Handler: AssignPlan, Tag: "plans", Permission: config.PermPlansManage,
+		Summary: "Назначить тариф компании",
+		Headers: []apiParam{
+			requiredHeader("companyINN", "ИНН компании"),
+			requiredHeader("planCode", "Код тарифа"),
+			optionalHeader("expiresAt", "Окончание в RFC 3339; без него — бессрочно"),
+		}},
+
+	// Уведомления
+	{Method: http.MethodGet, Path: "/listGrantAlertSubscriptions", Handler: ListGrantAlertSubscriptions, Tag: "notifications",
+		Summary: "Подписки пользователя на новые гранты"},
+	{Method: http.MethodPost, Path: "/subscribeGrantAlerts", Handler: SubscribeGrantAlerts, Tag: "notifications",
+		Summary: "Подписаться на новые гранты, подходящие компании по ОКВЭД и региону",
+		Headers: []apiParam{activeCompanyHeader, optionalHeader("digest", "true — одно письмо в день вместо письма на каждый грант")}},
+	{Method: http.MethodPost, Path: "/unsubscribeGrantAlerts", Handler: UnsubscribeGrantAlerts, Tag: "notifications",
+		Summary: "Отписаться от новых грантов для компании", Headers: []apiParam{activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/unsubscribeGrantAlertsByLink", Handler: ConfirmUnsubscribeGrantAlerts, Tag: "notifications", Public: true,
+		Summary: "Страница подтверждения отписки по ссылке из письма; сама ссылка не отписывает",
+		Query:   []apiParam{requiredQuery("token", "Токен отписки из письма")}},
+	{Method: http.MethodPost, Path: "/unsubscribeGrantAlertsByLink", Handler: UnsubscribeGrantAlertsByToken, Tag: "notifications", Public: true,
+		Summary: "Отписаться по ссылке из письма: форма страницы подтверждения или One-Click (RFC 8058)",
+		Query:   []apiParam{requiredQuery("token", "Токен отписки из письма")}},
+	{Method: http.MethodGet, Path: "/listNotifications", Handler: ListNotifications, Tag: "notifications",
+		Summary: "Уведомления пользователя", Headers: []apiParam{optionalHeader("unread", "true — только непрочитанные")}},
+	{Method: http.MethodPost, Path: "/markNotificationsRead", Handler: MarkNotificationsRead, Tag: "notifications",
+		Summary: "Отметить уведомления прочитанными", Headers: []apiParam{optionalHeader("notificationId", "Одно уведомление; без него — все")}},
+
+	// Постановления
+	{Method: http.MethodPost, Path: "/createDecree", Handler: CreateDecree, Tag: "decrees", Permission: config.PermDecreeWrite,
+		Summary: "Создать постановление", Body: &apiBody{Entity: "decree", Files: []string{"file"}}},
+	{Method: http.MethodGet, Path: "/listDecree", Handler: ListDecree, Tag: "decrees", Permission: config.PermDecreeRead,
+		Summary: "Список постановлений"},
+	{Method: http.MethodGet, Path: "/downloadDecree", Handler: DownloadDecree, Tag: "decrees", Permission: config.PermDecreeRead,
+		Summary: "Скачать файл постановления", Headers: []apiParam{requiredHeader("decreeID", "ID постановления")}},
+	{Method: http.MethodPost, Path: "/editDecree", Handler: EditDecree, Tag: "decrees", Permission: config.PermDecreeWrite,
+		Summary: "Изменить постановление", Headers: []apiParam{requiredHeader("decreeId", "ID постановления")}, Body: &apiBody{Entity: "decree"}},
+	{Method: http.MethodPost, Path: "/deleteDecree", Handler: DeleteDecree, Tag: "decrees", Permission: config.PermDecreeWrite,
+		Summary: "Удалить постановление", Headers: []apiParam{requiredHeader("decreeId", "ID постановления")}},
+
+	// Гранты
+	{Method: http.MethodPost, Path: "/createGrant", Handler: CreateGrant, Tag: "grants", Permission: config.PermGrantWrite,
+		Summary: "Создать грант", Body: &apiBody{Entity: "grant", Files: []string{"files"}}},
+	{Method: http.MethodGet, Path: "/listGrant", Handler: ListGrant, Tag: "grants", Permission: config.PermGrantRead,
+		Summary: "Список грантов"},
+	{Method: http.MethodPost, Path: "/editGrant", Handler: EditGrant, Tag: "grants", Permission: config.PermGrantWrite,
+		Summary: "Изменить грант", Headers: []apiParam{requiredHeader("grantId", "ID гранта")}, Body: &apiBody{Entity: "grant"}},
+	{Method: http.MethodPost, Path: "/deleteGrant", Handler: DeleteGrant, Tag: "grants", Permission: config.PermGrantWrite,
+		Summary: "Удалить грант", Headers: []apiParam{requiredHeader("grantId", "ID гранта")}},
+	{Method: http.MethodGet, Path: "/findGrant", Handler: FindGrant, Tag: "grants",
+		Summary: "Подбор грантов для компании пользователя", Headers: []apiParam{activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/findRegionalGrant", Handler: FindRegionalGrant, Tag: "grants",
+		Summary: "Гранты региона компании пользователя", Headers: []apiParam{activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/findGrantAnon", Handler: FindGrantAnon, Tag: "grants", Public: true,
+		Summary: "Гранты региона компании без регистрации", Headers: []apiParam{requiredHeader("companyINN", "ИНН компании")}},
+	{Method: http.MethodGet, Path: "/listGrantCatalog", Handler: ListGrantCatalog, Tag: "grants",
+		Summary: "Каталог грантов с фильтрами и поиском, от новых к старым",
+		Query: []apiParam{
+			optionalQuery("q", "Поиск по тексту гранта и постановления"),
+			optionalQuery("region", "Регион постановления"),
+			optionalQuery("okved", "Код ОКВЭД: гранты для него, его группы и кодов внутри него"),
+			optionalQuery("status", "draft, published, open, closed или archived; черновики и архив — с правом grant:write"),
+			optionalQuery("deadlineFrom", "Срок подачи не раньше даты (2006-01-02 или RFC 3339); гранты без срока проходят"),
+			optionalQuery("deadlineTo", "Срок подачи не позже даты"),
+			optionalQuery("amountMin", "Сумма поддержки от, руб."),
+			optionalQuery("amountMax", "Сумма поддержки до, руб."),
+			optionalQuery("cursor", "nextCursor предыдущей страницы"),
+			optionalQuery("limit", "Размер страницы, до 100 (по умолчанию 20)"),
+		}},
+	{Method: http.MethodPost, Path: "/setGrantTerms", Handler: SetGrantTerms, Tag: "grants", Permission: config.PermGrantWrite,
+		Summary: "Задать название, описание, сумму и окно приёма заявок гранта",
+		Headers: []apiParam{requiredHeader("grantId", "ID гранта")}, Body: &apiBody{Entity: "terms", JSONOnly: true}},
+	{Method: http.MethodPost, Path: "/changeGrantStatus", Handler: ChangeGrantStatus, Tag: "grants", Permission: config.PermGrantWrite,
+		Summary: "Сменить статус гранта: черновик, публикация, открытие, досрочное закрытие, архив",
+		Headers: []apiParam{requiredHeader("grantId", "ID гранта"), requiredHeader("status", "draft, published, open, closed или archived")}},
+
+	// Шаблоны
+	{Method: http.MethodPost, Path: "/createSample", Handler: CreateSample, Tag: "samples", Permission: config.PermSampleWrite,
+		Summary: "Создать шаблон заявки", Body: &apiBody{Entity: "sample"}},
+	{Method: http.MethodGet, Path: "/listSample", Handler: ListSample, Tag: "samples", Permission: config.PermSampleRead,
+		Summary: "Список шаблонов"},
+	{Method: http.MethodPost, Path: "/editSample", Handler: EditSample, Tag: "samples", Permission: config.PermSampleWrite,
+		Summary: "Изменить шаблон", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона")}, Body: &apiBody{Entity: "sample"}},
+	{Method: http.MethodPost, Path: "/deleteSample", Handler: DeleteSample, Tag: "samples", Permission: config.PermSampleWrite,
+		Summary: "Удалить шаблон", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона")}},
+
+	// Заполнение заявки
+	{Method: http.MethodPost, Path: "/startUserSample", Handler: StartUserSample, Tag: "filling",
+		Summary: "Начать заявку по шаблону для активной компании; повторный вызов возвращает начатую заявку",
+		Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader}},
+	{Method: http.MethodPost, Path: "/manualFileUpload", Handler: ManualFileUpload, Tag: "filling",
+		Summary: "Загрузить документ заявки",
+		Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader, requiredHeader("fileName", "Имя документа из списка к загрузке")},
+		Body:    &apiBody{Files: []string{"file"}}},
+	{Method: http.MethodPost, Path: "/findRequiredFieldsAI", Handler: FindRequiredFieldsAI, Tag: "filling",
+		Summary: "Запустить извлечение полей ИИ", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader}},
+	{Method: http.MethodPost, Path: "/retryFindRequiredFieldsAI", Handler: RetryFindRequiredFieldsAI, Tag: "filling",
+		Summary: "Повторить извлечение полей ИИ после неудачи", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/getAIJobStatus", Handler: GetAIJobStatus, Tag: "filling",
+		Summary: "Прогресс извлечения полей ИИ", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/getFieldsToFill", Handler: GetFieldsToFill, Tag: "filling",
+		Summary: "Поля для заполнения",
+		Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader, optionalHeader("withSources", "true — вернуть источник каждого поля")}},
+	{Method: http.MethodPost, Path: "/fillRequiredFields", Handler: FillRequiredFields, Tag: "filling",
+		Summary: "Сохранить заполненные поля", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader},
+		Body: &apiBody{Entity: "filledFields", JSONOnly: true}},
+	{Method: http.MethodPost, Path: "/confirmFilling", Handler: ConfirmFilling, Tag: "filling",
+		Summary: "Подтвердить заполнение", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader}},
+	{Method: http.MethodGet, Path: "/previewFill", Handler: PreviewFill, Tag: "filling",
+		Summary: "Предпросмотр заполненного документа",
+		Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader, requiredHeader("fileName", "Имя документа")}},
+	{Method: http.MethodGet, Path: "/getZipPDFs", Handler: GetZipPDFs, Tag: "filling",
+		Summary: "Архив заполненных документов", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader}},
+	{Method: http.MethodPost, Path: "/uploadSignedZip", Handler: UploadSignedZip, Tag: "filling",
+		Summary: "Загрузить подписанный архив", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader},
+		Body: &apiBody{Files: []string{"file"}}},
+	{Method: http.MethodGet, Path: "/getReadyArchives", Handler: GetReadyArchives, Tag: "filling",
+		Summary: "Скачать готовый архив",
+		Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader, requiredHeader("typeArchive", "Тип архива")}},
+	{Method: http.MethodPost, Path: "/mailSignedZip", Handler: MailSignedZip, Tag: "filling",
+		Summary: "Отправить подписанный архив на почту", Headers: []apiParam{requiredHeader("sampleId", "ID шаблона"), activeCompanyHeader}},
+
+	// Этапы заявки
+	{Method: http.MethodGet, Path: "/getUserSampleHistory", Handler: GetUserSampleHistory, Tag: "workflow",
+		Summary: "История этапов заявки",
+		Headers: []apiParam{optionalHeader("sampleId", "ID шаблона (своя заявка)"), activeCompanyHeader, optionalHeader("userSampleId", "ID заявки (право userSample:read)")}},
+	{Method: http.MethodPost, Path: "/rewindUserSample", Handler: RewindUserSample, Tag: "workflow", Permission: config.PermUserSampleRewind,
+		Summary: "Откатить заявку на более ранний этап",
+		Headers: []apiParam{requiredHeader("userSampleId", "ID заявки"), requiredHeader("status", "Этап"), optionalHeader("reason", "Причина")}},
+
+	// Администрирование
+	{Method: http.MethodGet, Path: "/listRolePermissions", Handler: ListRolePermissions, Tag: "admin", Permission: config.PermPermissionsManage,
+		Summary: "Права ролей"},
+	{Method: http.MethodPost, Path: "/grantRolePermission", Handler: GrantRolePermission, Tag: "admin", Permission: config.PermPermissionsManage,
+		Summary: "Выдать право роли", Headers: []apiParam{requiredHeader("role", "Роль"), requiredHeader("permission", "Право")}},
+	{Method: http.MethodPost, Path: "/revokeRolePermission", Handler: RevokeRolePermission, Tag: "admin", Permission: config.PermPermissionsManage,
+		Summary: "Отозвать право у роли", Headers: []apiParam{requiredHeader("role", "Роль"), requiredHeader("permission", "Право")}},
+	{Method: http.MethodGet, Path: "/listModeratorRegions", Handler: ListModeratorRegions, Tag: "admin", Permission: config.PermModeratorsManage,
+		Summary: "Регионы модераторов", Headers: []apiParam{optionalHeader("userId", "ID модератора")}},
+	{Method: http.MethodPost, Path: "/assignModeratorRegion", Handler: AssignModeratorRegion, Tag: "admin", Permission: config.PermModeratorsManage,
+		Summary: "Назначить регион модератору", Headers: []apiParam{requiredHeader("userId", "ID модератора")}, Query: []apiParam{requiredQuery("region", "Регион")}},
+	{Method: http.MethodPost, Path: "/unassignModeratorRegion", Handler: UnassignModeratorRegion, Tag: "admin", Permission: config.PermModeratorsManage,
+		Summary: "Снять регион с модератора", Headers: []apiParam{requiredHeader("userId", "ID модератора")}, Query: []apiParam{requiredQuery("region", "Регион")}},
+	{Method: http.MethodGet, Path: "/listLogs", Handler: ListLogs, Tag: "admin", Permission: config.PermLogsRead,
+		Summary: "Журнал действий", Headers: []apiParam{optionalHeader("userId", "ID пользователя")}},
+	{Method: http.MethodGet, Path: "/listRateLimitAllowlist", Handler: ListRateLimitAllowlist, Tag: "admin", Permission: config.PermRateLimitManage,
+		Summary: "Адреса партнёров без ограничения запросов"},
+	{Method: http.MethodPost, Path: "/addRateLimitAllowlist", Handler: AddRateLimitAllowlist, Tag: "admin", Permission: config.PermRateLimitManage,
+		Summary: "Снять ограничение запросов для адреса партнёра",
+		Headers: []apiParam{requiredHeader("address", "IP-адрес или подсеть CIDR"), optionalHeader("note", "Комментарий")}},
+	{Method: http.MethodPost, Path: "/removeRateLimitAllowlist", Handler: RemoveRateLimitAllowlist, Tag: "admin", Permission: config.PermRateLimitManage,
+		Summary: "Вернуть ограничение запросов для адреса", Headers: []apiParam{requiredHeader("address", "IP-адрес или подсеть CIDR")}},
+	{Method: http.MethodGet, Path: "/listZCBUsage", Handler: ListZCBUsage, Tag: "admin", Permission: config.PermZCBUsageRead,
+		Summary: "Платные запросы к ZCB по дням"},
+	{Method: http.MethodGet, Path: "/listEligibilityRules", Handler: ListEligibilityRules, Tag: "admin", Permission: config.PermEligibilityManage,
+		Summary: "Правила проверки компаний", Headers: []apiParam{optionalHeader("decreeId", "Общие правила и правила постановления")}},
+	{Method: http.MethodPost, Path: "/createEligibilityRule", Handler: CreateEligibilityRule, Tag: "admin", Permission: config.PermEligibilityManage,
+		Summary: "Создать правило проверки компаний", Body: &apiBody{Entity: "rule", JSONOnly: true}},
+	{Method: http.MethodPost, Path: "/updateEligibilityRule", Handler: UpdateEligibilityRule, Tag: "admin", Permission: config.PermEligibilityManage,
+		Summary: "Изменить правило проверки компаний", Headers: []apiParam{requiredHeader("ruleId", "ID правила")},
+		Body: &apiBody{Entity: "rule", JSONOnly: true}},
+	{Method: http.MethodPost, Path: "/deleteEligibilityRule", Handler: DeleteEligibilityRule, Tag: "admin", Permission: config.PermEligibilityManage,
+		Summary: "Удалить правило проверки компаний", Headers: []apiParam{requiredHeader("ruleId", "ID правила")}},
+}
+
+// AddAPIRoutes регистрирует версионированные маршруты /api/v1 и их OpenAPI-описание
+func AddAPIRoutes(e *echo.Echo) {
+	g := e.Group(apiV1Prefix)
+
+	seen := make(map[string]bool)
+	for _, route := range apiV1Routes {
+		// Дубликат в таблице перезаписал бы обработчик и описание — лучше упасть при старте
+		key := route.Method + " " + route.Path
+		if seen[key] || route.Handler == nil {
+			panic("invalid api route: " + key)
+		}
+		seen[key] = true
+
+		var guards []echo.MiddlewareFunc
+		if route.Permission != "" {
+			guards = append(guards, RequirePermission(route.Permission))
+		} else if !route.Public {
+			guards = append(guards, RequireUser)
+		}
+		g.Add(route.Method, route.Path, route.Handler, guards...)
+	}
+
+	g.GET("/openapi.json", GetOpenAPISpec)
+}
+
+var openAPISpec struct {
+	sync.Once
+	spec map[string]interface{}
+}
+
+func GetOpenAPISpec(c echo.Context) error {
+	openAPISpec.Do(func() {
+		openAPISpec.spec = buildOpenAPISpec(apiV1Routes)
+	})
+	return c.JSON(http.StatusOK, openAPISpec.spec)
+}
+
+func openAPIParameter(param apiParam, in string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        param.Name,
+		"in":          in,
+		"required":    param.Required,
+		"description": param.Description,
+		"schema":      map[string]string{"type": "string"},
+	}
+}
+
+func buildOpenAPISpec(routes []apiRoute) map[string]interface{} {
+	paths := make(map[string]interface{})
+
+	for _, route := range routes {
+		operation := map[string]interface{}{
+			"operationId": strings.TrimPrefix(route.Path, "/"),
+			"summary":     route.Summary,
+			"tags":        []string{route.Tag},
+			"responses":   openAPIResponses(route),
+		}
+
+		if !route.Public {
+			operation["security"] = []map[string][]string{{"accessToken": {}}}
+		}
+		if route.Permission != "" {
+			operation["description"] = "Требуется право " + route.Permission
+		}
+
+		var parameters []map[string]interface{}
+		for _, header := range route.Headers {
+			parameters = append(parameters, openAPIParameter(header, "header"))
+		}
+		for _, query := range route.Query {
+			parameters = append(parameters, openAPIParameter(query, "query"))
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.Body != nil {
+			operation["requestBody"] = openAPIRequestBody(route.Body)
+		}
+
+		item, ok := paths[route.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "Park API",
+			"version": "1.0.0",
+		},
+		"servers": []map[string]string{{"url": apiV1Prefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"accessToken": map[string]string{"type": "apiKey", "in": "header", "name": "accessToken"},
+			},
+			"schemas": openAPISchemas,
+		},
+	}
+}
+
+func openAPIRequestBody(body *apiBody) map[string]interface{} {
+	content := make(map[string]interface{})
+
+	multipart := make(map[string]interface{})
+	var requiredParts []string
+	if body.Entity != "" {
+		ref := map[string]string{"$ref": "#/components/schemas/" + body.Entity}
+		content[echo.MIMEApplicationJSON] = map[string]interface{}{"schema": ref}
+		multipart[body.Entity] = ref
+		requiredParts = append(requiredParts, body.Entity)
+	}
+	for _, file := range body.Files {
+		multipart[file] = map[string]string{"type": "string", "format": "binary"}
+		requiredParts = append(requiredParts, file)
+	}
+	if body.JSONOnly {
+		return map[string]interface{}{"required": true, "content": content}
+	}
+	if len(body.Files) > 0 {
+		// Файлы передаются только multipart — JSON-вариант тела недоступен
+		delete(content, echo.MIMEApplicationJSON)
+	}
+	content[echo.MIMEMultipartForm] = map[string]interface{}{
+		"schema": map[string]interface{}{
+			"type":       "object",
+			"properties": multipart,
+			"required":   requiredParts,
+		},
+	}
+
+	return map[string]interface{}{"required": true, "content": content}
+}
+
+func openAPIResponses(route apiRoute) map[string]interface{} {
+	responses := map[string]interface{}{
+		"200": map[string]string{"description": "OK"},
+	}
+	if route.Body != nil {
+		responses["400"] = map[string]interface{}{
+			"description": "Ошибка проверки полей",
+			"content": map[string]interface{}{
+				echo.MIMEApplicationJSON: map[string]interface{}{
+					"schema": map[string]string{"$ref": "#/components/schemas/validationError"},
+				},
+			},
+		}
+	}
+	if !route.Public {
+		responses["401"] = map[string]string{"description": "Требуется вход"}
+		responses["403"] = map[string]string{"description": "Нет доступа"}
+	}
+	if _, ok := quotaRoutes[route.Path]; ok {
+		responses["402"] = map[string]string{"description": "Исчерпан лимит тарифа"}
+	}
+	// Все ошибки отдаются одним конвертом, см. HTTPErrorHandler
+	responses["default"] = map[string]interface{}{
+		"description": "Ошибка",
+		"content": map[string]interface{}{
+			echo.MIMEApplicationJSON: map[string]interface{}{
+				"schema": map[string]string{"$ref": "#/components/schemas/error"},
+			},
+		},
+	}
+	return responses
+}
+
+var openAPISchemas = map[string]interface{}{
+	"decree": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"region"},
+		"properties": map[string]interface{}{
+			"region":    map[string]string{"type": "string"},
+			"city":      map[string]string{"type": "string"},
+			"okvedList": map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}},
+		},
+	},
+	"grant": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"decreeId"},
+		"properties": map[string]interface{}{
+			"decreeId":    map[string]string{"type": "integer"},
+			"documents":   map[string]interface{}{"type": "object", "additionalProperties": map[string]string{"type": "boolean"}},
+			"instruction": map[string]string{"type": "string"},
+		},
+	},
+	"sample": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"grantId"},
+		"properties": map[string]interface{}{
+			"grantId": map[string]string{"type": "integer"},
+		},
+	},
+	"filledFields": map[string]interface{}{
+		"type":                 "object",
+		"description":          "Значения полей вида {\"{{Поле}}\": \"значение\"}",
+		"additionalProperties": map[string]string{"type": "string"},
+	},
+	"rule": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"source", "path", "operator", "message"},
+		"properties": map[string]interface{}{
+			"decreeId":  map[string]interface{}{"type": "integer", "nullable": true, "description": "Пусто — общее правило"},
+			"source":    map[string]interface{}{"type": "string", "enum": []string{config.EligibilitySourceCard, config.EligibilitySourceFssp, config.EligibilitySourceFns}},
+			"path":      map[string]string{"type": "string", "description": "jsonslice-пути через ;"},
+			"operator":  map[string]interface{}{"type": "string", "enum": config.EligibilityOperators},
+			"value":     map[string]string{"type": "string", "description": "Порог или список через ;"},
+			"match":     map[string]interface{}{"type": "string", "enum": []string{config.EligibilityMatchAny, config.EligibilityMatchAll}},
+			"whenPath":  map[string]string{"type": "string"},
+			"whenValue": map[string]string{"type": "string"},
+			"message":   map[string]string{"type": "string", "description": "{value} заменяется нарушившим правило значением"},
+			"position":  map[string]string{"type": "integer"},
+			"disabled":  map[string]string{"type": "boolean"},
+		},
+	},
+	"error": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"error", "message"},
+		"properties": map[string]interface{}{
+			"error":   map[string]string{"type": "string", "description": "Машинный код ошибки"},
+			"message": map[string]string{"type": "string", "description": "Сообщение на языке из Accept-Language"},
+			"details": map[string]string{"type": "object"},
+		},
+	},
+	"validationError": map[string]interface{}{
+		"type":     "object",
+		"required": []string{"error", "message", "details"},
+		"properties": map[string]interface{}{
+			"error":   map[string]interface{}{"type": "string", "enum": []string{"validation_failed"}},
+			"message": map[string]string{"type": "string"},
+			"details": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"fields": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"field":   map[string]string{"type": "string"},
+								"message": map[string]string{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
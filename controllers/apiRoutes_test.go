@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Параметры строки запроса (c.QueryParam) handlerHeaders отличает от заголовков этим префиксом
+const queryPrefix = "?"
+
+// handlerHeaders собирает заголовки и параметры строки запроса, которые читают функции пакета: напрямую
+// через Header.Get и QueryParam и через вызванные ими функции пакета (activeCompany, findUserSample и т. п.)
+func handlerHeaders(t *testing.T) map[string]map[string]bool {
+	paths, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	funcs := make(map[string]*ast.FuncDecl)
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Body != nil {
+				funcs[fn.Name.Name] = fn
+			}
+		}
+	}
+
+	direct := make(map[string]map[string]bool)
+	calls := make(map[string][]string)
+	for name, fn := range funcs {
+		direct[name] = make(map[string]bool)
+		ast.Inspect(fn.Body, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				if _, ok := funcs[fun.Name]; ok {
+					calls[name] = append(calls[name], fun.Name)
+				}
+			case *ast.SelectorExpr:
+				prefix := ""
+				if fun.Sel.Name == "QueryParam" {
+					prefix = queryPrefix
+				} else if fun.Sel.Name != "Get" || !readsHeader(fun.X) {
+					return true
+				}
+				if len(call.Args) != 1 {
+					return true
+				}
+				if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					header, _ := strconv.Unquote(lit.Value)
+					direct[name][prefix+header] = true
+				}
+			}
+			return true
+		})
+	}
+
+	reachable := make(map[string]map[string]bool)
+	var visit func(name string, headers map[string]bool, seen map[string]bool)
+	visit = func(name string, headers map[string]bool, seen map[string]bool) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		for header := range direct[name] {
+			headers[header] = true
+		}
+		for _, callee := range calls[name] {
+			visit(callee, headers, seen)
+		}
+	}
+	for name := range funcs {
+		headers := make(map[string]bool)
+		visit(name, headers, make(map[string]bool))
+		reachable[name] = headers
+	}
+	return reachable
+}
+
+// readsHeader — выражение вида c.Request().Header или переменная header
+func readsHeader(expr ast.Expr) bool {
+	switch x := expr.(type) {
+	case *ast.SelectorExpr:
+		return x.Sel.Name == "Header"
+	case *ast.Ident:
+		return x.Name == "header"
+	}
+	return false
+}
+
+// Заголовки, которые OpenAPI описывает не параметрами: accessToken — схемой безопасности, Content-Type — телом запроса
+var undocumentedHeaders = map[string]bool{"accessToken": true, "Content-Type": true}
+
+func handlerName(handler interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Заголовки и параметры строки запроса в OpenAPI совпадают с теми, что читают обработчики
+func TestAPIRouteHeadersMatchHandlers(t *testing.T) {
+	read := handlerHeaders(t)
+
+	for _, route := range apiV1Routes {
+		name := handlerName(route.Handler)
+		headers, ok := read[name]
+		if !ok {
+			t.Errorf("%s: обработчик %s не найден в исходниках", route.Path, name)
+			continue
+		}
+
+		documented := make(map[string]bool)
+		for _, header := range route.Headers {
+			documented[header.Name] = true
+			if !headers[header.Name] {
+				t.Errorf("%s: заголовок %s описан, но %s его не читает", route.Path, header.Name, name)
+			}
+		}
+		for _, query := range route.Query {
+			documented[queryPrefix+query.Name] = true
+			if !headers[queryPrefix+query.Name] {
+				t.Errorf("%s: параметр %s описан, но %s его не читает", route.Path, query.Name, name)
+			}
+		}
+		for _, header := range sortedKeys(headers) {
+			if documented[header] || undocumentedHeaders[header] {
+				continue
+			}
+			if strings.HasPrefix(header, queryPrefix) {
+				t.Errorf("%s: %s читает параметр %s, которого нет в описании", route.Path, name, strings.TrimPrefix(header, queryPrefix))
+			} else {
+				t.Errorf("%s: %s читает заголовок %s, которого нет в описании", route.Path, name, header)
+			}
+		}
+	}
+}
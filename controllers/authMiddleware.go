@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"park/config"
+	"time"
+)
+
+const contextUserKey = "user"
+
+var errTokenNotFound = errors.New("token not found")
+var errTokenExpired = errors.New("token expired")
+
+// AuthMiddleware определяет пользователя по заголовку accessToken и кладёт его в контекст.
+// Запросы без токена или с недействительным токеном проходят анонимно — решение принимает маршрут;
+// заблокированные пользователи отсекаются сразу.
+func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		accessToken := c.Request().Header.Get("accessToken")
+		if accessToken == "" {
+			return next(c)
+		}
+
+		user, err := resolveAccessToken(accessToken)
+		if err != nil {
+			return next(c)
+		}
+
+		if user.IsSuspended {
+			return NewAPIError(http.StatusForbidden, "user_suspended")
+		}
+
+		c.Set(contextUserKey, user)
+		return next(c)
+	}
+}
+
+// resolveAccessToken находит пользователя по токену; просроченный токен удаляется
+func resolveAccessToken(accessToken string) (config.User, error) {
+	db := config.DB()
+
+	var token config.Token
+	res := db.Where(config.Token{AccessToken: accessToken}).First(&token)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return config.User{}, errTokenNotFound
+	} else if token.ValidTrough.Time.Before(time.Now()) {
+		db.Delete(&token, token.ID)
+		return config.User{}, errTokenExpired
+	}
+
+	// Токены, выданные сессией, живут меньше суток — срок хранится в RefreshToken
+	var session config.RefreshToken
+	res = db.Where(config.RefreshToken{AccessTokenID: token.ID}).Limit(1).Find(&session)
+	if res.Error == nil && res.RowsAffected > 0 && session.AccessExpiresAt.Before(time.Now()) {
+		db.Delete(&token, token.ID)
+		return config.User{}, errTokenExpired
+	}
+
+	var user config.User
+	res = db.Where(config.User{ID: token.UserID}).First(&user)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return config.User{}, errTokenNotFound
+	}
+
+	return user, nil
+}
+
+// contextUser возвращает пользователя, определённого AuthMiddleware; ID == 0 — запрос анонимный
+func contextUser(c echo.Context) config.User {
+	user, ok := c.Get(contextUserKey).(config.User)
+	if !ok {
+		return config.User{}
+	}
+	return user
+}
+
+// RequireUser пропускает только авторизованных пользователей
+func RequireUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if contextUser(c).ID == 0 {
+			return NewAPIError(http.StatusUnauthorized, "unauthorized")
+		}
+		return next(c)
+	}
+}
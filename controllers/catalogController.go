@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"park/config"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	catalogDefaultLimit = 20
+	catalogMaxLimit     = 100
+	// Без регистрации показывается только начало подборки
+	anonGrantLimit = 6
+)
+
+// Текст, по которому ищет каталог: условия гранта, строки гранта (инструкция, названия документов)
+// и постановления (файл, регион, муниципалитет, ОКВЭД). LEFT JOIN без условий даёт NULL, поэтому coalesce.
+// Вектор строится при изменении гранта, условий или постановления и хранится в GrantSearch
+const catalogSearchVector = `to_tsvector('russian', coalesce(grant_terms.title, '') || ' ' || coalesce(grant_terms.description, '')) ||
+	jsonb_to_tsvector('russian', to_jsonb(grants), '["string"]') ||
+	jsonb_to_tsvector('russian', to_jsonb(decrees), '["string"]')`
+
+// Грант без условий считается открытым
+const catalogStatusColumn = `coalesce(nullif(grant_terms.status, ''), '` + config.GrantStatusOpen + `')`
+
+// Класс ОКВЭД из двух цифр, затем подкласс, группа и подгруппа: 62, 62.0, 62.01, 62.01.1
+var okvedPattern = regexp.MustCompile(`^\d{2}(\.\d{1,2}(\.\d{1,2})?)?$`)
+
+// catalogItem — грант каталога вместе с постановлением, условиями и шаблонами заявок
+type catalogItem struct {
+	Grant   config.Grant       `json:"grant"`
+	Decree  config.Decree      `json:"decree"`
+	Terms   *config.GrantTerms `json:"terms"`
+	Samples []config.Sample    `json:"samples"`
+}
+
+func decreeIdsOf(decrees []config.Decree) []int {
+	ids := make([]int, 0, len(decrees))
+	for _, decree := range decrees {
+		ids = append(ids, decree.ID)
+	}
+	return ids
+}
+
+// decreeGrants загружает открытые гранты постановлений одним запросом, новые первыми; limit > 0 ограничивает их общее число
+func decreeGrants(db *gorm.DB, decreeIds []int, limit int) ([]config.Grant, error) {
+	grants := []config.Grant{}
+	if len(decreeIds) == 0 {
+		return grants, nil
+	}
+
+	query := openGrants(db.Where("decree_id IN ?", decreeIds)).Order("id desc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&grants).Error
+	return grants, err
+}
+
+// grantSamples загружает шаблоны заявок грантов одним запросом
+func grantSamples(db *gorm.DB, grants []config.Grant) ([]config.Sample, error) {
+	samples := []config.Sample{}
+	if len(grants) == 0 {
+		return samples, nil
+	}
+
+	grantIds := make([]int, 0, len(grants))
+	for _, grant := range grants {
+		grantIds = append(grantIds, grant.ID)
+	}
+	err := db.Where("grant_id IN ?", grantIds).Order("id").Find(&samples).Error
+	return samples, err
+}
+
+// catalogDecreeIds — подзапрос постановлений под фильтры региона и ОКВЭД по DecreeIndex. Названия регионов
+// и коды ОКВЭД сравниваются так же, как при подборе грантов: фильтр «62» находит «62.01», а «62.01» —
+// постановление с кодом «62». nil — фильтров нет, подходят все постановления
+func catalogDecreeIds(db *gorm.DB, region string, okved string) *gorm.DB {
+	region = normalizePlaceName(region)
+	if region == "" && okved == "" {
+		return nil
+	}
+
+	query := db.Model(&config.DecreeIndex{}).Select("decree_id")
+	if region != "" {
+		query = query.Where("region = ?", region)
+	}
+	if okved != "" {
+		key, _ := json.Marshal([]string{okvedKey(okved)})
+		covers := config.DB().Where("okved_prefixes @> ?::jsonb", string(key))
+		for _, ancestor := range okvedAncestors(okved) {
+			code, _ := json.Marshal([]string{ancestor})
+			covers = covers.Or("okved_codes @> ?::jsonb", string(code))
+		}
+		query = query.Where(covers)
+	}
+	return query
+}
+
+// syncGrantSearch пересчитывает GrantSearch грантов, подходящих под условие where по grants
+func syncGrantSearch(db *gorm.DB, where string, args ...interface{}) error {
+	return db.Exec(`INSERT INTO grant_searches (grant_id, document)
+		SELECT grants.id, `+catalogSearchVector+` FROM grants
+		JOIN decrees ON decrees.id = grants.decree_id
+		LEFT JOIN grant_terms ON grant_terms.grant_id = grants.id
+		WHERE `+where+`
+		ON CONFLICT (grant_id) DO UPDATE SET document = excluded.document`, args...).Error
+}
+
+// InitGrantSearch строит GrantSearch для грантов, у которых его ещё нет
+func InitGrantSearch() {
+	if err := syncGrantSearch(config.DB(), "grants.id NOT IN (SELECT grant_id FROM grant_searches)"); err != nil {
+		fmt.Println("Ошибка построения поискового индекса грантов:", err)
+	}
+}
+
+// parseCatalogDate принимает дату 2006-01-02 или момент в RFC 3339; endOfDay — дата означает конец дня
+func parseCatalogDate(value string, endOfDay bool) (time.Time, error) {
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			return date.Add(24*time.Hour - time.Nanosecond), nil
+		}
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func invalidCatalogParam(field string) error {
+	return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": field})
+}
+
+// ListGrantCatalog — каталог грантов с фильтрами и полнотекстовым поиском. Гранты отбираются одним запросом
+// с JOIN постановлений и условий, постановления, условия и шаблоны страницы догружаются по запросу на каждую
+// таблицу. Пагинация по курсору: nextCursor из ответа передаётся в параметре cursor, гранты идут от новых к старым
+func ListGrantCatalog(c echo.Context) error {
+	db := config.DB()
+
+	limit := catalogDefaultLimit
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return invalidCatalogParam("limit")
+		}
+		limit = parsed
+		if limit > catalogMaxLimit {
+			limit = catalogMaxLimit
+		}
+	}
+
+	query := db.Model(&config.Grant{}).
+		Joins("JOIN decrees ON decrees.id = grants.decree_id").
+		Joins("LEFT JOIN grant_terms ON grant_terms.grant_id = grants.id")
+
+	if value := c.QueryParam("cursor"); value != "" {
+		cursor, err := strconv.Atoi(value)
+		if err != nil || cursor <= 0 {
+			return invalidCatalogParam("cursor")
+		}
+		query = query.Where("grants.id < ?", cursor)
+	}
+
+	okved := strings.TrimSpace(c.QueryParam("okved"))
+	if okved != "" && !okvedPattern.MatchString(okved) {
+		return invalidCatalogParam("okved")
+	}
+	if decreeIds := catalogDecreeIds(db, strings.TrimSpace(c.QueryParam("region")), okved); decreeIds != nil {
+		query = query.Where("grants.decree_id IN (?)", decreeIds)
+	}
+
+	// Черновики и архив видят только те, кто ведёт гранты
+	status := c.QueryParam("status")
+	if status != "" && !config.IsGrantStatus(status) {
+		return invalidCatalogParam("status")
+	}
+	hidden := []string{config.GrantStatusDraft, config.GrantStatusArchived}
+	if !userCan(contextUser(c), config.PermGrantWrite) {
+		if containsString(hidden, status) {
+			return invalidCatalogParam("status")
+		}
+		query = query.Where(catalogStatusColumn+" NOT IN ?", hidden)
+	}
+	if status != "" {
+		query = query.Where(catalogStatusColumn+" = ?", status)
+	}
+
+	// Грант без срока принимает заявки постоянно, поэтому проходит нижнюю границу, но не верхнюю
+	if value := c.QueryParam("deadlineFrom"); value != "" {
+		from, err := parseCatalogDate(value, false)
+		if err != nil {
+			return invalidCatalogParam("deadlineFrom")
+		}
+		query = query.Where("(grant_terms.deadline IS NULL OR grant_terms.deadline >= ?)", from)
+	}
+	if value := c.QueryParam("deadlineTo"); value != "" {
+		to, err := parseCatalogDate(value, true)
+		if err != nil {
+			return invalidCatalogParam("deadlineTo")
+		}
+		query = query.Where("grant_terms.deadline <= ?", to)
+	}
+
+	// Диапазон суммы пересекается с диапазоном гранта; если известна одна граница, грант — одна сумма
+	if value := c.QueryParam("amountMin"); value != "" {
+		amount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || amount < 0 {
+			return invalidCatalogParam("amountMin")
+		}
+		query = query.Where("coalesce(grant_terms.amount_max, grant_terms.amount_min) >= ?", amount)
+	}
+	if value := c.QueryParam("amountMax"); value != "" {
+		amount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || amount < 0 {
+			return invalidCatalogParam("amountMax")
+		}
+		query = query.Where("coalesce(grant_terms.amount_min, grant_terms.amount_max) <= ?", amount)
+	}
+
+	if search := strings.TrimSpace(c.QueryParam("q")); search != "" {
+		query = query.Joins("JOIN grant_searches ON grant_searches.grant_id = grants.id").
+			Where("grant_searches.document @@ websearch_to_tsquery('russian', ?)", search)
+	}
+
+	// Лишняя запись показывает, есть ли следующая страница
+	var grants []config.Grant
+	if err := query.Select("grants.*").Order("grants.id desc").Limit(limit + 1).Find(&grants).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	var nextCursor *int
+	if len(grants) > limit {
+		grants = grants[:limit]
+		nextCursor = &grants[limit-1].ID
+	}
+
+	items, err := catalogItems(db, grants)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items":      items,
+		"nextCursor": nextCursor,
+	})
+}
+
+// catalogItems дополняет гранты страницы постановлениями, условиями и шаблонами — по запросу на таблицу
+func catalogItems(db *gorm.DB, grants []config.Grant) ([]catalogItem, error) {
+	items := make([]catalogItem, 0, len(grants))
+	if len(grants) == 0 {
+		return items, nil
+	}
+
+	grantIds := make([]int, 0, len(grants))
+	decreeIds := make([]int, 0, len(grants))
+	for _, grant := range grants {
+		grantIds = append(grantIds, grant.ID)
+		decreeIds = append(decreeIds, grant.DecreeID)
+	}
+
+	var decrees []config.Decree
+	if err := db.Where("id IN ?", decreeIds).Find(&decrees).Error; err != nil {
+		return nil, err
+	}
+	decreeById := make(map[int]config.Decree, len(decrees))
+	for _, decree := range decrees {
+		decreeById[decree.ID] = decree
+	}
+
+	var terms []config.GrantTerms
+	if err := db.Where("grant_id IN ?", grantIds).Find(&terms).Error; err != nil {
+		return nil, err
+	}
+	termsByGrant := make(map[int]*config.GrantTerms, len(terms))
+	for i := range terms {
+		termsByGrant[terms[i].GrantID] = &terms[i]
+	}
+
+	samples, err := grantSamples(db, grants)
+	if err != nil {
+		return nil, err
+	}
+	samplesByGrant := make(map[int][]config.Sample)
+	for _, sample := range samples {
+		samplesByGrant[sample.GrantID] = append(samplesByGrant[sample.GrantID], sample)
+	}
+
+	for _, grant := range grants {
+		item := catalogItem{
+			Grant:   grant,
+			Decree:  decreeById[grant.DecreeID],
+			Terms:   termsByGrant[grant.ID],
+			Samples: samplesByGrant[grant.ID],
+		}
+		if item.Samples == nil {
+			item.Samples = []config.Sample{}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/bhmj/jsonslice"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"park/config"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// companyTrackedFields — как получить значение отслеживаемого поля из карточки компании (ответ ZCB card)
+var companyTrackedFields = []struct {
+	Field string
+	Value func(card []byte) string
+}{
+	{config.CompanyFieldAddress, cardPathValue("$.body.docs.0.Адрес")},
+	{config.CompanyFieldRegion, cardPathValue("$.body.docs.0.НаимРегион")},
+	{config.CompanyFieldOkved, cardPathValue("$.body.docs.0.КодОКВЭД")},
+	{config.CompanyFieldOkvedAdditional, cardPathValue("$.body.docs.0.СвОКВЭДДоп[*].КодОКВЭД")},
+	{config.CompanyFieldMSPCategory, cardPathValue("$.body.docs.0.КатСубМСП.1")},
+	{config.CompanyFieldRevenue, cardLatestRevenue},
+}
+
+// cardPathValue — значения по пути через "; "; списки сортируются, чтобы порядок в ответе ZCB не считался изменением
+func cardPathValue(path string) func(card []byte) string {
+	return func(card []byte) string {
+		var values []string
+		for _, value := range ruleValues(card, path) {
+			if s := ruleValueString(value); s != "" {
+				values = append(values, s)
+			}
+		}
+		sort.Strings(values)
+		return strings.Join(values, "; ")
+	}
+}
+
+// cardLatestRevenue — выручка за последний год, по которому в карточке есть отчётность (поля ФО<год>)
+func cardLatestRevenue(card []byte) string {
+	doc, err := jsonslice.Get(card, "$.body.docs.0")
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return ""
+	}
+
+	latestYear := 0
+	revenue := ""
+	for key, report := range fields {
+		year, err := strconv.Atoi(strings.TrimPrefix(key, "ФО"))
+		if !strings.HasPrefix(key, "ФО") || err != nil || year <= latestYear {
+			continue
+		}
+		value := cardPathValue("$.ВЫРУЧКА")(report)
+		if value == "" {
+			continue
+		}
+		latestYear = year
+		revenue = value + " (" + strconv.Itoa(year) + ")"
+	}
+	return revenue
+}
+
+// recordCompanyChanges сохраняет отслеживаемые поля, которые различаются в прежней и новой карточке компании.
+// Без прежней карточки сравнивать не с чем — компания только появилась
+func recordCompanyChanges(db *gorm.DB, companyId int, oldCard []byte, newCard []byte) error {
+	if len(oldCard) == 0 || len(newCard) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var changes []config.CompanyChange
+	for _, tracked := range companyTrackedFields {
+		oldValue := tracked.Value(oldCard)
+		newValue := tracked.Value(newCard)
+		if oldValue == newValue {
+			continue
+		}
+		changes = append(changes, config.CompanyChange{
+			CompanyID:  companyId,
+			Field:      tracked.Field,
+			OldValue:   oldValue,
+			NewValue:   newValue,
+			DetectedAt: now,
+		})
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return db.Create(&changes).Error
+}
+
+// upsertCompany сохраняет данные компании, найденной по ИНН: существующую обновляет, записывая изменения
+// карточки, новую создаёт
+func upsertCompany(db *gorm.DB, company config.Company) (config.Company, bool, error) {
+	var existing config.Company
+	res := db.Where(config.Company{INN: company.INN}).Limit(1).Find(&existing)
+	if res.Error != nil {
+		return existing, false, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		err := db.Create(&company).Error
+		return company, true, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := recordCompanyChanges(tx, existing.ID, existing.CardData, company.CardData); err != nil {
+			return err
+		}
+		return tx.Model(&existing).Updates(company).Error
+	})
+	return existing, false, err
+}
+
+func ListCompanyChanges(c echo.Context) error {
+	db := config.DB()
+
+	inn := c.Request().Header.Get("inn")
+	if inn == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "inn"})
+	}
+
+	var company config.Company
+	res := db.Where(config.Company{INN: inn}).Limit(1).Find(&company)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "company_not_found")
+	}
+
+	user := contextUser(c)
+	if _, linked := userCompanyRole(user, company); !linked && !userCan(user, config.PermCompanyChangesRead) {
+		return permissionDenied(user)
+	}
+
+	query := db.Where(config.CompanyChange{CompanyID: company.ID})
+	if since := c.Request().Header.Get("since"); since != "" {
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "since"})
+		}
+		query = query.Where("detected_at >= ?", sinceTime)
+	}
+
+	var changes []config.CompanyChange
+	if err := query.Order("id desc").Limit(200).Find(&changes).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, changes)
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const zcbRequestTimeout = 30 * time.Second
+
+// CompanyDataProvider — источник данных о компаниях: карточка, долги ФССП, выписка ФНС
+type CompanyDataProvider interface {
+	// Fetch возвращает ответ запроса endpoint (config.ZCBEndpoint*) по ключу key — ИНН для card, ОГРН для fssp и fns
+	Fetch(endpoint string, key string) (json.RawMessage, error)
+}
+
+var (
+	// errCompanyDataNotFound — у источника нет данных по ключу, повторять запрос бессмысленно
+	errCompanyDataNotFound = errors.New("company data not found")
+	// errCompanyDataUnavailable — источник недоступен или ответил ошибкой; это не повод отказывать компании
+	errCompanyDataUnavailable = errors.New("company data provider unavailable")
+)
+
+var companyDataProvider CompanyDataProvider
+
+// InitCompanyDataProvider выбирает источник по COMPANY_DATA_PROVIDER: zcb (по умолчанию) или fixtures —
+// JSON-файлы <COMPANY_DATA_FIXTURES_PATH>/<endpoint>/<ключ>.json для тестов и демонстраций без платного API
+func InitCompanyDataProvider() {
+	provider, _ := os.LookupEnv("COMPANY_DATA_PROVIDER")
+
+	switch provider {
+	case "", "zcb":
+		apiKey, _ := os.LookupEnv("ZCB_API_KEY")
+		companyDataProvider = &zcbProvider{apiKey: apiKey, client: &http.Client{Timeout: zcbRequestTimeout}}
+	case "fixtures":
+		dir, ok := os.LookupEnv("COMPANY_DATA_FIXTURES_PATH")
+		if !ok {
+			panic("Environment variable COMPANY_DATA_FIXTURES_PATH not set")
+		}
+		companyDataProvider = &fixtureProvider{dir: dir}
+	default:
+		panic(fmt.Sprintf("Unknown company data provider %s", provider))
+	}
+}
+
+// zcbProvider — платный API zachestnyibiznesapi.ru; каждый запрос учитывается в ZCBUsage
+type zcbProvider struct {
+	apiKey string
+	client *http.Client
+}
+
+func (p *zcbProvider) Fetch(endpoint string, key string) (json.RawMessage, error) {
+	endpointURL, ok := zcbEndpointURLs[endpoint]
+	if !ok {
+		return nil, fmt.Errorf("unknown company data endpoint %s", endpoint)
+	}
+
+	query := url.Values{"id": {key}, "api_key": {p.apiKey}}
+	resp, err := p.client.Get(endpointURL + "?" + query.Encode())
+	if err != nil {
+		// В url.Error есть адрес запроса вместе с api_key — в ошибку он попасть не должен
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%w: %v", errCompanyDataUnavailable, err)
+	}
+	defer resp.Body.Close()
+	countZCBCall(endpoint)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s ответил %d", errCompanyDataUnavailable, endpoint, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCompanyDataUnavailable, err)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%w: %s вернул не JSON", errCompanyDataUnavailable, endpoint)
+	}
+	return body, nil
+}
+
+// fixtureProvider читает заранее сохранённые ответы ZCB из каталога
+type fixtureProvider struct {
+	dir string
+}
+
+func (p *fixtureProvider) Fetch(endpoint string, key string) (json.RawMessage, error) {
+	if _, ok := zcbEndpointURLs[endpoint]; !ok {
+		return nil, fmt.Errorf("unknown company data endpoint %s", endpoint)
+	}
+
+	// Ключ приходит от пользователя — не даём выйти за пределы каталога
+	data, err := os.ReadFile(filepath.Join(p.dir, endpoint, filepath.Base(key)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s/%s", errCompanyDataNotFound, endpoint, key)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCompanyDataUnavailable, err)
+	}
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%w: %s/%s.json не JSON", errCompanyDataUnavailable, endpoint, key)
+	}
+	return data, nil
+}
+
+// companyDataError — ответ клиенту на ошибку источника: отсутствие данных — компания не найдена,
+// недоступность источника — 503, чтобы клиент повторил проверку позже
+func companyDataError(err error) error {
+	if errors.Is(err, errCompanyDataNotFound) {
+		return NewAPIError(http.StatusForbidden, "company_not_found")
+	}
+	return NewAPIError(http.StatusServiceUnavailable, "company_data_unavailable").WithCause(err)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"park/config"
+	"path/filepath"
+	"testing"
+)
+
+func writeFixture(t *testing.T, dir string, endpoint string, key string, data string) {
+	if err := os.MkdirAll(filepath.Join(dir, endpoint), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, endpoint, key+".json"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFixtureProviderFetch(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, config.ZCBEndpointCard, "7707083893", `{"body":{"docs":[{"ОГРН":"1027700132195"}]}}`)
+	writeFixture(t, dir, config.ZCBEndpointFssp, "1027700132195", `not json`)
+	// Файл вне каталога endpoint не должен читаться через ключ с ../
+	writeFixture(t, dir, ".", "secret", `{}`)
+
+	provider := &fixtureProvider{dir: dir}
+
+	tests := []struct {
+		name     string
+		endpoint string
+		key      string
+		want     error
+	}{
+		{"found", config.ZCBEndpointCard, "7707083893", nil},
+		{"missing key", config.ZCBEndpointCard, "0000000000", errCompanyDataNotFound},
+		{"missing endpoint dir", config.ZCBEndpointFns, "1027700132195", errCompanyDataNotFound},
+		{"invalid json", config.ZCBEndpointFssp, "1027700132195", errCompanyDataUnavailable},
+		{"path traversal", config.ZCBEndpointCard, "../secret", errCompanyDataNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := provider.Fetch(tt.endpoint, tt.key)
+			if tt.want == nil {
+				if err != nil || len(data) == 0 {
+					t.Fatalf("Fetch() = %q, %v; want data", data, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Fetch() error = %v; want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := provider.Fetch("unknown", "7707083893"); err == nil {
+		t.Fatal("Fetch() with unknown endpoint: want error")
+	}
+}
+
+func TestCompanyDataError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errCompanyDataNotFound, http.StatusForbidden, "company_not_found"},
+		{errCompanyDataUnavailable, http.StatusServiceUnavailable, "company_data_unavailable"},
+	}
+	for _, tt := range tests {
+		var apiErr *APIError
+		if !errors.As(companyDataError(tt.err), &apiErr) {
+			t.Fatalf("companyDataError(%v) is not an APIError", tt.err)
+		}
+		if apiErr.Status != tt.status || apiErr.Code != tt.code {
+			t.Errorf("companyDataError(%v) = %d %s; want %d %s", tt.err, apiErr.Status, apiErr.Code, tt.status, tt.code)
+		}
+	}
+}
+
+type failingProvider struct {
+	err error
+}
+
+func (p failingProvider) Fetch(endpoint string, key string) (json.RawMessage, error) {
+	return nil, p.err
+}
+
+// Сбой источника проваливает задачу ZCB без повторов: её ждёт запрос пользователя
+func TestZCBJobFailsWithoutRetry(t *testing.T) {
+	saved := companyDataProvider
+	defer func() { companyDataProvider = saved }()
+
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{errCompanyDataNotFound, jobFailureCompanyDataNotFound},
+		{errCompanyDataUnavailable, jobFailureCompanyDataUnavailable},
+	}
+	for _, tt := range tests {
+		companyDataProvider = failingProvider{err: tt.err}
+
+		payload, _ := json.Marshal(zcbJobPayload{ID: "7707083893", Endpoint: config.ZCBEndpointCard})
+		_, err := jobHandlers[jobTypeZCBRequest](config.Job{Type: jobTypeZCBRequest, Payload: payload})
+
+		var failure *JobFailure
+		if !errors.As(err, &failure) || failure.Code != tt.code {
+			t.Errorf("ZCB job with %v: error = %v; want JobFailure %s", tt.err, err, tt.code)
+		}
+	}
+}
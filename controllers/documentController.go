@@ -3,55 +3,47 @@ package controllers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"github.com/bhmj/jsonslice"
 	"github.com/labstack/echo/v4"
-	minio2 "github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+	"mime/multipart"
 	"net/http"
-	"os"
 	"park/config"
 	"strconv"
-	"time"
+	"strings"
 )
 
-var ipRequestFindAnon = make(map[string]map[string]int)
-
 func CreateDecree(c echo.Context) error {
 	db := config.DB()
-	minio := config.MinioClient()
-
-	accessToken := c.Request().Header.Get("accessToken")
-	newDecree := c.Request().Header.Get("newDecree")
+	storage := config.Storage()
 
-	if newDecree == "" {
-		return c.JSON(http.StatusForbidden, nil)
-	}
-
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermDecreeWrite) {
+		return permissionDenied(user)
 	}
 
 	var decree config.Decree
 
-	if err := json.Unmarshal([]byte(newDecree), &decree); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+	if err := bindEntity(c, "decree", "newDecree", &decree); err != nil {
+		return err
+	}
+	// Файл проверяется вместе с полями до записи в базу, чтобы не оставлять постановлений без файла
+	file, fileErr := c.FormFile("file")
+	if err := validateDecree(decree, fileErr == nil); err != nil {
+		return err
 	}
 
-	if err := db.Create(&decree).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+	if !canAccessRegion(user, decree.Region) {
+		return regionForbidden(c)
 	}
 
-	file, err := c.FormFile("file")
-	if err != nil {
-		println(err.Error())
-		return c.String(http.StatusBadRequest, "Failed to get file")
+	if err := db.Create(&decree).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	// Открываем файл из запроса
 	src, err := file.Open()
 	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to open uploaded file: %v", err))
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 	defer src.Close()
 
@@ -59,25 +51,25 @@ func CreateDecree(c echo.Context) error {
 	objectName := "decree/" + strconv.Itoa(decree.ID) + "/" + file.Filename
 	contentType := file.Header.Get("Content-Type")
 
-	bucket, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-
-	// Загружаем файл в MinIO
-	_, err = minio.PutObject(
+	// Загружаем файл в хранилище
+	err = storage.PutObject(
 		context.Background(),
-		bucket,
 		objectName,
 		src, // Используем поток файла напрямую
 		file.Size,
-		minio2.PutObjectOptions{ContentType: contentType},
+		contentType,
 	)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to upload to MinIO: %v", err))
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
 	decree.FileName = file.Filename
 	errDecree := db.Save(&decree).Error
 	if errDecree != nil {
-		return c.JSON(http.StatusForbidden, nil)
+		return wrapError(http.StatusForbidden, "database_error", errDecree)
+	}
+	if err := syncDecreeIndex(db, decree); err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	decreeIdStr := strconv.Itoa(decree.ID)
@@ -89,35 +81,32 @@ func CreateDecree(c echo.Context) error {
 func ListDecree(c echo.Context) error {
 	db := config.DB()
 
-	accessToken := c.Request().Header.Get("accessToken")
-
-	userRole := CheckUserRole(accessToken)
-	if userRole != "admin" && userRole != "moderator" {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermDecreeRead) {
+		return permissionDenied(user)
 	}
 
 	var decrees []config.Decree
-	errDecrees := db.Where(config.Decree{}).Find(&decrees).Error
+	errDecrees := scopeDecreesByRegion(db.Where(config.Decree{}), user).Find(&decrees).Error
 	if errDecrees != nil {
-		return c.JSON(http.StatusForbidden, nil)
+		return wrapError(http.StatusForbidden, "database_error", errDecrees)
 	}
 	return c.JSON(http.StatusOK, decrees)
 }
 
 func DownloadDecree(c echo.Context) error {
 	db := config.DB()
-	minio := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	decreeID := c.Request().Header.Get("decreeID")
 
 	if decreeID == "" {
-		return c.JSON(http.StatusForbidden, nil)
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "decreeID"})
 	}
 
-	userRole := CheckUserRole(accessToken)
-	if userRole != "admin" && userRole != "moderator" {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermDecreeRead) {
+		return permissionDenied(user)
 	}
 
 	decreeIDINT, _ := strconv.Atoi(decreeID)
@@ -126,24 +115,29 @@ func DownloadDecree(c echo.Context) error {
 
 	errDecree := db.Where(config.Decree{ID: decreeIDINT}).First(&decree)
 	if errDecree.Error != nil || errDecree.RowsAffected == 0 {
-		return c.JSON(http.StatusForbidden, nil)
+		return NewAPIError(http.StatusForbidden, "not_found")
+	}
+
+	if !canAccessRegion(user, decree.Region) {
+		return regionForbidden(c)
 	}
 
-	bucket, _ := os.LookupEnv("MINIO_BUCKET_NAME")
+	objectName := "decree/" + decreeID + "/" + decree.FileName
 
-	object, err := minio.GetObject(c.Request().Context(), bucket, "decree/"+decreeID+"/"+decree.FileName, minio2.GetObjectOptions{})
+	stat, err := storage.StatObject(c.Request().Context(), objectName)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, "Failed to get object from MinIO: "+err.Error())
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
-	stat, err := object.Stat()
+	object, err := storage.GetObject(c.Request().Context(), objectName)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, "Failed to get object metadata: "+err.Error())
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
+	defer object.Close()
 
 	c.Response().Header().Set("Content-Disposition", "attachment; filename="+decree.FileName)
 	c.Response().Header().Set("Content-Type", stat.ContentType)
-	c.Response().Header().Set("Content-Length", string(stat.Size))
+	c.Response().Header().Set("Content-Length", strconv.FormatInt(stat.Size, 10))
 
 	return c.Stream(http.StatusOK, stat.ContentType, object)
 
@@ -152,23 +146,29 @@ func DownloadDecree(c echo.Context) error {
 func EditDecree(c echo.Context) error {
 	db := config.DB()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	decreeId := c.Request().Header.Get("decreeId")
-	editedDecree := c.Request().Header.Get("editedDecree")
 
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermDecreeWrite) {
+		return permissionDenied(user)
 	}
 
 	var decree config.Decree
 	if err := db.First(&decree, decreeId).Error; err != nil {
-		return c.JSON(http.StatusNotFound, nil)
+		return NewAPIError(http.StatusNotFound, "not_found")
 	}
 
 	var updatedDecree config.Decree
-	if err := json.Unmarshal([]byte(editedDecree), &updatedDecree); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+	if err := bindEntity(c, "decree", "editedDecree", &updatedDecree); err != nil {
+		return err
+	}
+	if err := validateDecree(updatedDecree, true); err != nil {
+		return err
+	}
+
+	// Модератор не может ни править чужой регион, ни перенести постановление в чужой регион
+	if !canAccessRegion(user, decree.Region) || !canAccessRegion(user, updatedDecree.Region) {
+		return regionForbidden(c)
 	}
 
 	updatedDecree.ID = decree.ID
@@ -177,7 +177,14 @@ func EditDecree(c echo.Context) error {
 	decree = updatedDecree
 
 	if err := db.Save(&decree).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	if err := syncDecreeIndex(db, decree); err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	// Строки постановления входят в поисковый текст его грантов
+	if err := syncGrantSearch(db, "grants.decree_id = ?", decree.ID); err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	AddLog(user.ID, "Edit Decree", decreeId)
@@ -187,45 +194,44 @@ func EditDecree(c echo.Context) error {
 
 func DeleteDecree(c echo.Context) error {
 	db := config.DB()
-	minio := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	decreeId := c.Request().Header.Get("decreeId")
 
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermDecreeWrite) {
+		return permissionDenied(user)
 	}
 
 	var decree config.Decree
 	if err := db.First(&decree, decreeId).Error; err != nil {
-		return c.JSON(http.StatusNotFound, nil)
+		return NewAPIError(http.StatusNotFound, "not_found")
 	}
 
-	// Удаляем файл из MinIO
-	bucket, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	prefix := "decree/" + strconv.Itoa(decree.ID) + "/"
+	if !canAccessRegion(user, decree.Region) {
+		return regionForbidden(c)
+	}
 
-	objectCh := minio.ListObjects(c.Request().Context(), bucket, minio2.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: true,
-	})
+	// Удаляем файл из хранилища
+	prefix := "decree/" + strconv.Itoa(decree.ID) + "/"
 
-	for object := range objectCh {
-		if object.Err != nil {
-			return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Error listing objects: %v", object.Err))
-		}
+	objects, err := storage.ListObjects(c.Request().Context(), prefix, true)
+	if err != nil {
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
+	}
 
-		err := minio.RemoveObject(c.Request().Context(), bucket, object.Key, minio2.RemoveObjectOptions{})
+	for _, object := range objects {
+		err := storage.RemoveObject(c.Request().Context(), object.Key)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to delete object %s: %v", object.Key, err))
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 	}
 
 	// Удаляем запись из базы данных
 	if err := db.Delete(&decree).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
+	db.Where(config.DecreeIndex{DecreeID: decree.ID}).Delete(&config.DecreeIndex{})
 
 	AddLog(user.ID, "Delete Decree", decreeId)
 
@@ -234,49 +240,51 @@ func DeleteDecree(c echo.Context) error {
 
 func CreateGrant(c echo.Context) error {
 	db := config.DB()
-	minio := config.MinioClient()
-
-	accessToken := c.Request().Header.Get("accessToken")
-	newGrant := c.Request().Header.Get("newGrant")
+	storage := config.Storage()
 
-	if newGrant == "" {
-		return c.JSON(http.StatusForbidden, nil)
-	}
-
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermGrantWrite) {
+		return permissionDenied(user)
 	}
 
 	var grant config.Grant
 
-	if err := json.Unmarshal([]byte(newGrant), &grant); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+	if err := bindEntity(c, "grant", "newGrant", &grant); err != nil {
+		return err
 	}
-
-	if err := db.Create(&grant).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+	// Получаем список файлов из формы; они проверяются вместе с полями до записи в базу
+	var files []*multipart.FileHeader
+	if form, err := c.MultipartForm(); err == nil {
+		files = form.File["files"] // `files` — ключ массива файлов в запросе
+	}
+	if err := validateGrant(grant, true, len(files) > 0); err != nil {
+		return err
 	}
 
-	// Получаем список файлов из формы
-	form, err := c.MultipartForm()
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, "Failed to parse multipart form")
+	if !canAccessDecree(user, grant.DecreeID) {
+		return regionForbidden(c)
 	}
 
-	files := form.File["files"] // `files` — ключ массива файлов в запросе
-	if len(files) == 0 {
-		return c.JSON(http.StatusBadRequest, "No files uploaded")
+	// Новый грант — черновик: подписчики узнают о нём, когда модератор опубликует его в ChangeGrantStatus.
+	// Условия создаются вместе с грантом, чтобы грант, файлы которого не загрузились, не попал в каталог
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&grant).Error; err != nil {
+			return err
+		}
+		terms := config.GrantTerms{GrantID: grant.ID, Status: config.GrantStatusDraft}
+		return saveGrantTerms(tx, &terms)
+	})
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
-	bucket, _ := os.LookupEnv("MINIO_BUCKET_NAME")
 	var fileNames []string
 
 	for _, file := range files {
 		// Открываем каждый файл
 		src, err := file.Open()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to open uploaded file: %v", err))
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 		defer src.Close()
 
@@ -284,17 +292,16 @@ func CreateGrant(c echo.Context) error {
 		objectName := "grant/" + strconv.Itoa(grant.ID) + "/" + file.Filename
 		contentType := file.Header.Get("Content-Type")
 
-		// Загружаем файл в MinIO
-		_, err = minio.PutObject(
+		// Загружаем файл в хранилище
+		err = storage.PutObject(
 			context.Background(),
-			bucket,
 			objectName,
 			src, // Используем поток файла напрямую
 			file.Size,
-			minio2.PutObjectOptions{ContentType: contentType},
+			contentType,
 		)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to upload file %s to MinIO: %v", file.Filename, err))
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 
 		fileNames = append(fileNames, file.Filename)
@@ -302,14 +309,14 @@ func CreateGrant(c echo.Context) error {
 
 	fileNamesJSON, err := json.Marshal(fileNames)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to marshal file names: %v", err))
+		return NewAPIError(http.StatusInternalServerError, "internal_error").WithCause(err)
 	}
 
 	grant.FileNames = fileNamesJSON
 
 	errGrant := db.Save(&grant).Error
 	if errGrant != nil {
-		return c.JSON(http.StatusForbidden, nil)
+		return wrapError(http.StatusForbidden, "database_error", errGrant)
 	}
 
 	AddLog(user.ID, "Create grant", strconv.Itoa(grant.ID))
@@ -319,17 +326,15 @@ func CreateGrant(c echo.Context) error {
 
 func ListGrant(c echo.Context) error {
 	db := config.DB()
-	accessToken := c.Request().Header.Get("accessToken")
-
-	userRole := CheckUserRole(accessToken)
-	if userRole != "admin" && userRole != "moderator" {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermGrantRead) {
+		return permissionDenied(user)
 	}
 
 	var grants []config.Grant
-	errGrants := db.Where(config.Grant{}).Find(&grants).Error
+	errGrants := scopeGrantsByRegion(db.Where(config.Grant{}), user).Find(&grants).Error
 	if errGrants != nil {
-		return c.JSON(http.StatusForbidden, nil)
+		return wrapError(http.StatusForbidden, "database_error", errGrants)
 	}
 	return c.JSON(http.StatusOK, grants)
 }
@@ -337,28 +342,26 @@ func ListGrant(c echo.Context) error {
 func FindGrant(c echo.Context) error {
 	db := config.DB()
 
-	accessToken := c.Request().Header.Get("accessToken")
-
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 
 	if user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
-	if user.Role == "admin" || user.Role == "tester" {
+	if userCan(user, config.PermCatalogAll) {
 		var decrees []config.Decree
 		if err := db.Order("id desc").Limit(50).Find(&decrees).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+			return wrapError(http.StatusInternalServerError, "database_error", err)
 		}
 
 		var grants []config.Grant
 		if err := db.Order("id desc").Limit(50).Find(&grants).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+			return wrapError(http.StatusInternalServerError, "database_error", err)
 		}
 
 		var samples []config.Sample
 		if err := db.Order("id desc").Limit(50).Find(&samples).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+			return wrapError(http.StatusInternalServerError, "database_error", err)
 		}
 
 		return c.JSON(http.StatusOK, map[string]interface{}{
@@ -366,107 +369,74 @@ func FindGrant(c echo.Context) error {
 			"grants":  grants,
 			"samples": samples,
 		})
-	} else if user.Role == "moderator" {
+	} else if userCan(user, config.PermDecreeWrite) {
+		// Модераторы работают с документами, а не подбирают гранты для своей компании
 		return c.JSON(http.StatusOK, "{\"decrees\": [], \"grants\": [], \"samples\": []}")
 	}
 
-	var company config.Company
-	errCompany := db.Where(config.Company{INN: user.CompanyINN}).First(&company)
-	if errCompany.Error != nil || errCompany.RowsAffected == 0 {
-		return c.JSON(http.StatusForbidden, nil)
-	}
-
-	tempList, _ := jsonslice.Get(company.CardData, "$.body.docs.0.СвОКВЭДДоп")
-	mainOkved, _ := jsonslice.Get(company.CardData, "$.body.docs.0.КодОКВЭД")
-	companyCity, _ := jsonslice.Get(company.CardData, "$.body.docs.0.НаимГород")
-	if string(companyCity) == "null" {
-		companyCity, _ = jsonslice.Get(company.CardData, "$.body.docs.0.НаимРайон")
-	}
-	companyRegion, _ := jsonslice.Get(company.CardData, "$.body.docs.0.НаимРегион")
-
-	var parsedCity, parsedRegion string
-	json.Unmarshal([]byte(companyCity), &parsedCity)
-	json.Unmarshal([]byte(companyRegion), &parsedRegion)
-
-	var items []map[string]interface{}
-	err := json.Unmarshal([]byte(tempList), &items)
+	company, err := activeCompany(c)
 	if err != nil {
-		return c.JSON(http.StatusForbidden, err)
+		return err
 	}
-
-	codes := []string{}
-	for _, item := range items {
-		if code, ok := item["КодОКВЭД"].(string); ok {
-			codes = append(codes, code)
-		}
+	if err := companyIneligibility(company.ID); err != nil {
+		return err
 	}
-	codes = append(codes, string(mainOkved)[1:len(mainOkved)-1])
 
-	var decrees []config.Decree
-	query := db.Model(&config.Decree{})
-
-	for _, code := range codes {
-		// Преобразуем JSONB в текст и ищем через LIKE
-		query = query.Or("(CAST(okved_list AS TEXT) LIKE ? AND (LOWER(COALESCE(city, '')) = LOWER(?) OR (COALESCE(city, '') = '' AND LOWER(region) = LOWER(?))) )", "%"+code+"%", parsedCity, parsedRegion)
+	// Место отбирается в SQL по DecreeIndex, оцениваются только постановления того же места
+	profile := companyProfileOf(company.CardData)
+	var candidates []config.Decree
+	if err := db.Where("id IN (?)", placeDecreeIds(db, profile)).Find(&candidates).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
+	matches := matchDecrees(profile, candidates, true)
 
-	errDecrees := query.Find(&decrees).Error
-	if errDecrees != nil {
-		return c.JSON(http.StatusInternalServerError, errDecrees)
+	decrees := make([]config.Decree, 0, len(matches))
+	decreeIds := make([]int, 0, len(matches))
+	for _, match := range matches {
+		decrees = append(decrees, match.Decree)
+		decreeIds = append(decreeIds, match.Decree.ID)
 	}
 
-	var grants []config.Grant
-
-	for i := range decrees {
-		var tmpGrants []config.Grant
-		err := db.Where(config.Grant{DecreeID: decrees[i].ID}).Find(&tmpGrants).Error
-		if err != nil {
-			continue
-		}
-		grants = append(grants, tmpGrants...)
+	grants, err := decreeGrants(db, decreeIds, 0)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
+	grants, grantMatches := rankGrants(matches, grants)
 
-	var samples []config.Sample
-
-	for i := range grants {
-		var tmpSamples []config.Sample
-		err := db.Where(config.Sample{GrantID: grants[i].ID}).Find(&tmpSamples).Error
-		if err != nil {
-			continue
-		}
-		samples = append(samples, tmpSamples...)
+	samples, err := grantSamples(db, grants)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"decrees": decrees,
 		"grants":  grants,
 		"samples": samples,
+		"matches": grantMatches,
 	})
-
 }
 
 func FindRegionalGrant(c echo.Context) error {
 	db := config.DB()
-	accessToken := c.Request().Header.Get("accessToken")
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 	if user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, "No user found")
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
-	if user.Role == "admin" || user.Role == "tester" {
+	if userCan(user, config.PermCatalogAll) {
 		var decrees []config.Decree
 		if err := db.Order("id desc").Limit(50).Find(&decrees).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+			return wrapError(http.StatusInternalServerError, "database_error", err)
 		}
 
 		var grants []config.Grant
 		if err := db.Order("id desc").Limit(50).Find(&grants).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+			return wrapError(http.StatusInternalServerError, "database_error", err)
 		}
 
 		var samples []config.Sample
 		if err := db.Order("id desc").Limit(50).Find(&samples).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+			return wrapError(http.StatusInternalServerError, "database_error", err)
 		}
 
 		return c.JSON(http.StatusOK, map[string]interface{}{
@@ -474,51 +444,32 @@ func FindRegionalGrant(c echo.Context) error {
 			"grants":  grants,
 			"samples": samples,
 		})
-	} else if user.Role == "moderator" {
+	} else if userCan(user, config.PermDecreeWrite) {
+		// Модераторы работают с документами, а не подбирают гранты для своей компании
 		return c.JSON(http.StatusOK, "{\"decrees\": [], \"grants\": [], \"samples\": []}")
 	}
 
-	var company config.Company
-	errCompany := db.Where(config.Company{INN: user.CompanyINN}).First(&company)
-	if errCompany.Error != nil || errCompany.RowsAffected == 0 {
-		return c.JSON(http.StatusForbidden, nil)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
 	}
-
-	companyRegion, _ := jsonslice.Get(company.CardData, "$.body.docs.0.НаимРегион")
-	var parsedRegion string
-	json.Unmarshal([]byte(companyRegion), &parsedRegion)
-
-	var decrees []config.Decree
-	query := db.Model(&config.Decree{})
-
-	// Преобразуем JSONB в текст и ищем через LIKE
-	query = query.Or("(LOWER(region) = LOWER(?))", parsedRegion)
-
-	errDecrees := query.Find(&decrees).Error
-	if errDecrees != nil {
-		return c.JSON(http.StatusInternalServerError, errDecrees)
+	if err := companyIneligibility(company.ID); err != nil {
+		return err
 	}
 
-	var grants []config.Grant
-
-	for i := range decrees {
-		var tmpGrants []config.Grant
-		err := db.Where(config.Grant{DecreeID: decrees[i].ID}).Find(&tmpGrants).Error
-		if err != nil {
-			continue
-		}
-		grants = append(grants, tmpGrants...)
+	decrees, err := regionalDecrees(db, company)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
-	var samples []config.Sample
+	grants, err := decreeGrants(db, decreeIdsOf(decrees), 0)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
 
-	for i := range grants {
-		var tmpSamples []config.Sample
-		err := db.Where(config.Sample{GrantID: grants[i].ID}).Find(&tmpSamples).Error
-		if err != nil {
-			continue
-		}
-		samples = append(samples, tmpSamples...)
+	samples, err := grantSamples(db, grants)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -529,70 +480,43 @@ func FindRegionalGrant(c echo.Context) error {
 }
 
 func FindGrantAnon(c echo.Context) error {
-
-	ip := c.RealIP()
-	today := time.Now().Format("2006-01-02")
-
-	if ipRequestFindAnon[ip] == nil {
-		ipRequestFindAnon[ip] = make(map[string]int)
+	if err := rateLimit(c, config.RateLimitFindGrantAnon); err != nil {
+		return err
 	}
-	if ipRequestFindAnon[ip][today] > 10 {
-		return c.JSON(http.StatusTooManyRequests, "Превышен лимит запросов на сегодня с этого IP")
-	}
-	ipRequestFindAnon[ip][today]++
 
 	db := config.DB()
 	companyINN := c.Request().Header.Get("companyINN")
 
 	if companyINN == "" {
-		return c.JSON(http.StatusUnauthorized, "No companyINN found")
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "companyINN"})
 	}
 
 	var company config.Company
 	errCompany := db.Where(config.Company{INN: companyINN}).First(&company)
 	if errCompany.Error != nil || errCompany.RowsAffected == 0 {
-		return c.JSON(http.StatusForbidden, "no company found")
+		return NewAPIError(http.StatusForbidden, "company_not_found")
+	}
+	if err := companyIneligibility(company.ID); err != nil {
+		return err
 	}
 
 	if company.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, "No company found")
+		return NewAPIError(http.StatusUnauthorized, "company_not_found")
 	}
 
-	companyRegion, _ := jsonslice.Get(company.CardData, "$.body.docs.0.НаимРегион")
-	var parsedRegion string
-	json.Unmarshal([]byte(companyRegion), &parsedRegion)
-
-	var decrees []config.Decree
-	query := db.Model(&config.Decree{})
-
-	// Преобразуем JSONB в текст и ищем через LIKE
-	query = query.Or("(LOWER(region) = LOWER(?))", parsedRegion)
-
-	errDecrees := query.Find(&decrees).Limit(6).Error
-	if errDecrees != nil {
-		return c.JSON(http.StatusInternalServerError, errDecrees)
+	decrees, err := regionalDecrees(db, company)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
-	var grants []config.Grant
-
-	for i := range decrees {
-		var tmpGrants []config.Grant
-		err := db.Where(config.Grant{DecreeID: decrees[i].ID}).Find(&tmpGrants).Limit(6).Error
-		if err != nil {
-			continue
-		}
-		grants = append(grants, tmpGrants...)
+	grants, err := decreeGrants(db, decreeIdsOf(decrees), anonGrantLimit)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
-	var samples []config.Sample
-
-	for i := range grants {
-		var tmpSamples []config.Sample
-		err := db.Where(config.Sample{GrantID: grants[i].ID}).Find(&tmpSamples).Limit(6).Error
-		if err != nil {
-			continue
-		}
-		samples = append(samples, tmpSamples...)
+	samples, err := grantSamples(db, grants)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -605,43 +529,44 @@ func FindGrantAnon(c echo.Context) error {
 func EditGrant(c echo.Context) error {
 	db := config.DB()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	grantId := c.Request().Header.Get("grantId")
-	editedGrant := c.Request().Header.Get("editedGrant")
-
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
-	}
 
-	if editedGrant == "" {
-		return c.JSON(http.StatusBadRequest, "No edited grant provided")
+	user := contextUser(c)
+	if !userCan(user, config.PermGrantWrite) {
+		return permissionDenied(user)
 	}
 
 	var grant config.Grant
 	if err := db.First(&grant, grantId).Error; err != nil {
-		return c.JSON(http.StatusNotFound, nil)
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if !canAccessDecree(user, grant.DecreeID) {
+		return regionForbidden(c)
 	}
 
 	var updatedGrant config.Grant
-	if err := json.Unmarshal([]byte(editedGrant), &updatedGrant); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+	if err := bindEntity(c, "grant", "editedGrant", &updatedGrant); err != nil {
+		return err
+	}
+	if err := validateGrant(updatedGrant, false, true); err != nil {
+		return err
 	}
 
 	var existingDocuments map[string]bool
 	if err := json.Unmarshal(grant.Documents, &existingDocuments); err != nil {
-		return c.JSON(http.StatusInternalServerError, "Failed to unmarshal existing Documents")
+		return NewAPIError(http.StatusInternalServerError, "internal_error").WithCause(err)
 	}
 
 	var updatedDocuments map[string]bool
 	if err := json.Unmarshal(updatedGrant.Documents, &updatedDocuments); err != nil {
-		return c.JSON(http.StatusBadRequest, "Failed to unmarshal updated Documents")
+		return NewAPIError(http.StatusBadRequest, "validation_failed").WithDetails(&ValidationError{Fields: []FieldError{{Field: "documents", Message: "ожидается объект вида {\"документ\": true}"}}})
 	}
 
 	for key, value := range existingDocuments {
 		if value {
 			if newVal, ok := updatedDocuments[key]; !ok || !newVal {
-				return c.JSON(http.StatusForbidden, fmt.Sprintf("Modification of document '%s' is not allowed", key))
+				return NewAPIError(http.StatusForbidden, "document_locked").WithDetails(map[string]string{"document": key})
 			}
 		}
 	}
@@ -663,7 +588,10 @@ func EditGrant(c echo.Context) error {
 	grant = updatedGrant
 
 	if err := db.Save(&grant).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	if err := syncGrantSearch(db, "grants.id = ?", grant.ID); err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	AddLog(user.ID, "Edit Grant", strconv.Itoa(grant.ID))
@@ -673,45 +601,45 @@ func EditGrant(c echo.Context) error {
 
 func DeleteGrant(c echo.Context) error {
 	db := config.DB()
-	minio := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	grantId := c.Request().Header.Get("grantId")
 
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermGrantWrite) {
+		return permissionDenied(user)
 	}
 
 	var grant config.Grant
 	if err := db.First(&grant, grantId).Error; err != nil {
-		return c.JSON(http.StatusNotFound, nil)
+		return NewAPIError(http.StatusNotFound, "not_found")
 	}
 
-	// Удаляем файл из MinIO
-	bucket, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	prefix := "grant/" + strconv.Itoa(grant.ID) + "/"
+	if !canAccessDecree(user, grant.DecreeID) {
+		return regionForbidden(c)
+	}
 
-	objectCh := minio.ListObjects(c.Request().Context(), bucket, minio2.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: true,
-	})
+	// Удаляем файл из хранилища
+	prefix := "grant/" + strconv.Itoa(grant.ID) + "/"
 
-	for object := range objectCh {
-		if object.Err != nil {
-			return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Error listing objects: %v", object.Err))
-		}
+	objects, err := storage.ListObjects(c.Request().Context(), prefix, true)
+	if err != nil {
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
+	}
 
-		err := minio.RemoveObject(c.Request().Context(), bucket, object.Key, minio2.RemoveObjectOptions{})
+	for _, object := range objects {
+		err := storage.RemoveObject(c.Request().Context(), object.Key)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to delete object %s: %v", object.Key, err))
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 	}
 
 	// Удаляем запись из базы данных
 	if err := db.Delete(&grant).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
+	db.Where(config.GrantTerms{GrantID: grant.ID}).Delete(&config.GrantTerms{})
+	db.Where(config.GrantSearch{GrantID: grant.ID}).Delete(&config.GrantSearch{})
 
 	AddLog(user.ID, "Delete Grant", strconv.Itoa(grant.ID))
 
@@ -721,26 +649,26 @@ func DeleteGrant(c echo.Context) error {
 func CreateSample(c echo.Context) error {
 	db := config.DB()
 
-	newData := c.Request().Header.Get("newData")
-	accessToken := c.Request().Header.Get("accessToken")
-
-	if newData == "" {
-		return c.JSON(http.StatusForbidden, nil)
-	}
-
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermSampleWrite) {
+		return permissionDenied(user)
 	}
 
 	var sample config.Sample
 
-	if err := json.Unmarshal([]byte(newData), &sample); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+	if err := bindEntity(c, "sample", "newData", &sample); err != nil {
+		return err
+	}
+	if err := validateSample(sample); err != nil {
+		return err
+	}
+
+	if !canAccessGrant(user, sample.GrantID) {
+		return regionForbidden(c)
 	}
 
 	if err := db.Create(&sample).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	AddLog(user.ID, "Create Sample", strconv.Itoa(sample.ID))
@@ -750,49 +678,52 @@ func CreateSample(c echo.Context) error {
 
 func ListSample(c echo.Context) error {
 	db := config.DB()
-	accessToken := c.Request().Header.Get("accessToken")
-
-	userRole := CheckUserRole(accessToken)
-	if userRole != "admin" && userRole != "moderator" {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermSampleRead) {
+		return permissionDenied(user)
 	}
 
 	var samples []config.Sample
-	errSamples := db.Where(config.Sample{}).Find(&samples).Error
+	errSamples := scopeSamplesByRegion(db.Where(config.Sample{}), user).Find(&samples).Error
 	if errSamples != nil {
-		return c.JSON(http.StatusForbidden, nil)
+		return wrapError(http.StatusForbidden, "database_error", errSamples)
 	}
 	return c.JSON(http.StatusOK, samples)
 }
 
 func EditSample(c echo.Context) error {
 	db := config.DB()
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
-	editedSample := c.Request().Header.Get("editedSample")
 
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermSampleWrite) {
+		return permissionDenied(user)
 	}
 
 	var sample config.Sample
 	if err := db.First(&sample, sampleId).Error; err != nil {
-		return c.JSON(http.StatusNotFound, nil)
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if !canAccessGrant(user, sample.GrantID) {
+		return regionForbidden(c)
 	}
 
 	var updatedSample config.Sample
-	if err := json.Unmarshal([]byte(editedSample), &updatedSample); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+	if err := bindEntity(c, "sample", "editedSample", &updatedSample); err != nil {
+		return err
 	}
 
 	updatedSample.ID = sample.ID
 	updatedSample.GrantID = sample.GrantID
+	if err := validateSample(updatedSample); err != nil {
+		return err
+	}
 
 	sample = updatedSample
 
 	if err := db.Save(&sample).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	AddLog(user.ID, "Edit Sample", strconv.Itoa(sample.ID))
@@ -803,25 +734,99 @@ func EditSample(c echo.Context) error {
 func DeleteSample(c echo.Context) error {
 	db := config.DB()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
 
-	user := getUserObject(accessToken)
-	if user.Role != "admin" && user.Role != "moderator" || user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+	user := contextUser(c)
+	if !userCan(user, config.PermSampleWrite) {
+		return permissionDenied(user)
 	}
 
 	var sample config.Sample
 	if err := db.First(&sample, sampleId).Error; err != nil {
-		return c.JSON(http.StatusNotFound, nil)
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if !canAccessGrant(user, sample.GrantID) {
+		return regionForbidden(c)
 	}
 
 	// Удаляем запись из базы данных
 	if err := db.Delete(&sample).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return wrapError(http.StatusInternalServerError, "database_error", err)
 	}
 
 	AddLog(user.ID, "Delete Sample", strconv.Itoa(sample.ID))
 
 	return c.JSON(http.StatusOK, nil)
 }
+
+// validateDecree проверяет постановление; hasFile — передан файл постановления (при правке файл не меняется)
+func validateDecree(decree config.Decree, hasFile bool) error {
+	validation := &ValidationError{}
+
+	if strings.TrimSpace(decree.Region) == "" {
+		validation.Add("region", "обязательное поле")
+	}
+	if !hasFile {
+		validation.Add("file", "обязательный файл")
+	}
+
+	// Список ОКВЭД — массив кодов, массив объектов с кодом или строка с кодами, см. decreeOkvedCodes
+	if len(decree.OkvedList) > 0 {
+		var list interface{}
+		json.Unmarshal(decree.OkvedList, &list)
+		switch list.(type) {
+		case nil, string, []interface{}:
+			for _, code := range decreeOkvedCodes(decree.OkvedList) {
+				if !okvedPattern.MatchString(code) {
+					validation.Add("okvedList", "некорректный код ОКВЭД: "+code)
+				}
+			}
+		default:
+			validation.Add("okvedList", "ожидается массив кодов ОКВЭД")
+		}
+	}
+
+	return validation.Err()
+}
+
+// validateGrant проверяет грант; DecreeID проверяется только при создании — при правке он не меняется.
+// hasFiles — переданы файлы гранта
+func validateGrant(grant config.Grant, checkDecree bool, hasFiles bool) error {
+	validation := &ValidationError{}
+
+	if !hasFiles {
+		validation.Add("files", "обязательный файл")
+	}
+
+	if checkDecree {
+		var decree config.Decree
+		if grant.DecreeID == 0 {
+			validation.Add("decreeId", "обязательное поле")
+		} else if err := config.DB().First(&decree, grant.DecreeID).Error; err != nil {
+			validation.Add("decreeId", "постановление не найдено")
+		}
+	}
+
+	if len(grant.Documents) > 0 {
+		var documents map[string]bool
+		if err := json.Unmarshal(grant.Documents, &documents); err != nil {
+			validation.Add("documents", "ожидается объект вида {\"документ\": true}")
+		}
+	}
+
+	return validation.Err()
+}
+
+func validateSample(sample config.Sample) error {
+	validation := &ValidationError{}
+
+	var grant config.Grant
+	if sample.GrantID == 0 {
+		validation.Add("grantId", "обязательное поле")
+	} else if err := config.DB().First(&grant, sample.GrantID).Error; err != nil {
+		validation.Add("grantId", "грант не найден")
+	}
+
+	return validation.Err()
+}
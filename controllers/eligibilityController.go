@@ -0,0 +1,493 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bhmj/jsonslice"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"park/config"
+	"strconv"
+	"strings"
+)
+
+// companySources — данные компании из ZCB, по которым проверяются правила
+type companySources struct {
+	Card []byte
+	Fssp []byte
+	Fns  []byte
+}
+
+func (s companySources) get(source string) []byte {
+	switch source {
+	case config.EligibilitySourceFssp:
+		return s.Fssp
+	case config.EligibilitySourceFns:
+		return s.Fns
+	}
+	return s.Card
+}
+
+// ruleOutcome — результат применения одного правила; Applied == false — не выполнено условие WhenPath.
+// Values — значения, которые правило увидело (для неприменённого — значения по WhenPath)
+type ruleOutcome struct {
+	Rule    config.EligibilityRule
+	Applied bool
+	Passed  bool
+	Values  []string
+	Rejects []string
+}
+
+// loadEligibilityRules возвращает включённые общие правила и, если задан decreeId, правила постановления
+func loadEligibilityRules(decreeId *int) ([]config.EligibilityRule, error) {
+	query := config.DB().Where("disabled = ?", false)
+	if decreeId != nil {
+		query = query.Where("decree_id IS NULL OR decree_id = ?", *decreeId)
+	} else {
+		query = query.Where("decree_id IS NULL")
+	}
+
+	var rules []config.EligibilityRule
+	err := query.Order("position, id").Find(&rules).Error
+	return rules, err
+}
+
+// evaluateEligibility применяет правила к данным компании; rejects — причины отказа без повторов
+func evaluateEligibility(rules []config.EligibilityRule, data companySources) (outcomes []ruleOutcome, rejects []string) {
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		outcome := evaluateRule(rule, data)
+		outcomes = append(outcomes, outcome)
+		for _, reject := range outcome.Rejects {
+			if !seen[reject] {
+				seen[reject] = true
+				rejects = append(rejects, reject)
+			}
+		}
+	}
+	return outcomes, rejects
+}
+
+func evaluateRule(rule config.EligibilityRule, data companySources) ruleOutcome {
+	doc := data.get(rule.Source)
+	outcome := ruleOutcome{Rule: rule, Applied: true, Passed: true}
+
+	if rule.WhenPath != "" {
+		allowed := splitRuleList(rule.WhenValue)
+		matched := false
+		for _, value := range ruleValues(doc, rule.WhenPath) {
+			outcome.Values = append(outcome.Values, ruleValueString(value))
+			if containsString(allowed, ruleValueString(value)) {
+				matched = true
+			}
+		}
+		if !matched {
+			outcome.Applied = false
+			return outcome
+		}
+	}
+
+	values := ruleValues(doc, rule.Path)
+	outcome.Values = nil
+	var failed []string
+	for _, value := range values {
+		outcome.Values = append(outcome.Values, ruleValueString(value))
+		if ok, detail := checkRuleValue(rule, value); !ok {
+			failed = append(failed, detail)
+		}
+	}
+
+	if len(failed) == 0 || (rule.Match == config.EligibilityMatchAny && len(failed) < len(values)) {
+		return outcome
+	}
+
+	outcome.Passed = false
+	if rule.Match == config.EligibilityMatchAny {
+		outcome.Rejects = []string{ruleMessage(rule, strings.Join(failed, ", "))}
+		return outcome
+	}
+	for _, detail := range failed {
+		outcome.Rejects = append(outcome.Rejects, ruleMessage(rule, detail))
+	}
+	return outcome
+}
+
+// ruleValues достаёт значения по путям правила; массивы раскрываются поэлементно,
+// отсутствующее значение даёт nil — как пустая строка в прежних проверках; путь с * может не дать ничего
+func ruleValues(doc []byte, paths string) []interface{} {
+	var values []interface{}
+	for _, path := range splitRuleList(paths) {
+		res, err := jsonslice.Get(doc, path)
+		if err != nil || len(bytes.TrimSpace(res)) == 0 {
+			if !strings.Contains(path, "*") {
+				values = append(values, nil)
+			}
+			continue
+		}
+
+		var value interface{}
+		if err := json.Unmarshal(res, &value); err != nil {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, flattenRuleValue(value)...)
+	}
+	return values
+}
+
+func flattenRuleValue(value interface{}) []interface{} {
+	items, ok := value.([]interface{})
+	if !ok {
+		return []interface{}{value}
+	}
+
+	values := []interface{}{}
+	for _, item := range items {
+		values = append(values, flattenRuleValue(item)...)
+	}
+	return values
+}
+
+// checkRuleValue проверяет одно значение; detail — то, что подставится в {value} сообщения
+func checkRuleValue(rule config.EligibilityRule, value interface{}) (bool, string) {
+	s := ruleValueString(value)
+
+	switch rule.Operator {
+	case config.EligibilityOpEq:
+		return s == rule.Value, s
+	case config.EligibilityOpNe:
+		return s != rule.Value, s
+	case config.EligibilityOpIn:
+		return containsString(splitRuleList(rule.Value), s), s
+	case config.EligibilityOpNotIn:
+		return !containsString(splitRuleList(rule.Value), s), s
+	case config.EligibilityOpGt, config.EligibilityOpGte, config.EligibilityOpLt, config.EligibilityOpLte:
+		actual, errActual := strconv.ParseFloat(s, 64)
+		threshold, errThreshold := strconv.ParseFloat(rule.Value, 64)
+		if errActual != nil || errThreshold != nil {
+			return false, s
+		}
+		switch rule.Operator {
+		case config.EligibilityOpGt:
+			return actual > threshold, s
+		case config.EligibilityOpGte:
+			return actual >= threshold, s
+		case config.EligibilityOpLt:
+			return actual < threshold, s
+		}
+		return actual <= threshold, s
+	case config.EligibilityOpNotBlockedOkved:
+		code := s
+		if okved, ok := value.(map[string]interface{}); ok {
+			code, _ = okved["КодОКВЭД"].(string)
+		}
+		if code == "" {
+			return true, ""
+		}
+
+		var blockedOkved config.BlockedOkveds
+		res := config.DB().Where(config.BlockedOkveds{Code: code}).Limit(1).Find(&blockedOkved)
+		if res.RowsAffected != 0 {
+			return false, blockedOkved.Code + ": " + blockedOkved.Name
+		}
+		return true, code
+	}
+	return false, s
+}
+
+func ruleValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func ruleMessage(rule config.EligibilityRule, detail string) string {
+	return strings.ReplaceAll(rule.Message, "{value}", detail)
+}
+
+func splitRuleList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validateEligibilityRule(rule config.EligibilityRule) error {
+	validation := &ValidationError{}
+
+	if rule.DecreeID != nil {
+		var decree config.Decree
+		if err := config.DB().First(&decree, *rule.DecreeID).Error; err != nil {
+			validation.Add("decreeId", "постановление не найдено")
+		}
+	}
+
+	switch rule.Source {
+	case config.EligibilitySourceCard, config.EligibilitySourceFssp, config.EligibilitySourceFns:
+	default:
+		validation.Add("source", "допустимые значения: card, fssp, fns")
+	}
+
+	paths := splitRuleList(rule.Path)
+	if len(paths) == 0 {
+		validation.Add("path", "обязательное поле")
+	}
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "$") {
+			validation.Add("path", "путь должен начинаться с $: "+path)
+		}
+	}
+
+	switch {
+	case !config.IsEligibilityOperator(rule.Operator):
+		validation.Add("operator", "допустимые значения: "+strings.Join(config.EligibilityOperators, ", "))
+	case rule.Operator == config.EligibilityOpGt || rule.Operator == config.EligibilityOpGte ||
+		rule.Operator == config.EligibilityOpLt || rule.Operator == config.EligibilityOpLte:
+		if _, err := strconv.ParseFloat(rule.Value, 64); err != nil {
+			validation.Add("value", "ожидается число")
+		}
+	case rule.Operator == config.EligibilityOpIn || rule.Operator == config.EligibilityOpNotIn:
+		if len(splitRuleList(rule.Value)) == 0 {
+			validation.Add("value", "ожидается список значений через ;")
+		}
+	}
+
+	if rule.Match != config.EligibilityMatchAny && rule.Match != config.EligibilityMatchAll {
+		validation.Add("match", "допустимые значения: any, all")
+	}
+
+	if (rule.WhenPath == "") != (rule.WhenValue == "") {
+		validation.Add("whenValue", "whenPath и whenValue задаются вместе")
+	}
+
+	if strings.TrimSpace(rule.Message) == "" {
+		validation.Add("message", "обязательное поле")
+	}
+
+	return validation.Err()
+}
+
+func ListEligibilityRules(c echo.Context) error {
+	db := config.DB()
+
+	decreeId := c.Request().Header.Get("decreeId")
+
+	query := db.Order("decree_id NULLS FIRST, position, id")
+	if decreeId != "" {
+		decreeIdInt, err := strconv.Atoi(decreeId)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "decreeId"})
+		}
+		query = query.Where("decree_id IS NULL OR decree_id = ?", decreeIdInt)
+	}
+
+	var rules []config.EligibilityRule
+	if err := query.Find(&rules).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, rules)
+}
+
+func CreateEligibilityRule(c echo.Context) error {
+	db := config.DB()
+
+	var rule config.EligibilityRule
+	if err := bindEntity(c, "rule", "rule", &rule); err != nil {
+		return err
+	}
+	rule.ID = 0
+	if rule.Match == "" {
+		rule.Match = config.EligibilityMatchAll
+	}
+
+	if err := validateEligibilityRule(rule); err != nil {
+		return err
+	}
+
+	if err := db.Create(&rule).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(contextUser(c).ID, "Create eligibility rule", strconv.Itoa(rule.ID))
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+func UpdateEligibilityRule(c echo.Context) error {
+	db := config.DB()
+
+	ruleId, err := strconv.Atoi(c.Request().Header.Get("ruleId"))
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "ruleId"})
+	}
+
+	var rule config.EligibilityRule
+	if err := db.First(&rule, ruleId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	var updatedRule config.EligibilityRule
+	if err := bindEntity(c, "rule", "rule", &updatedRule); err != nil {
+		return err
+	}
+	updatedRule.ID = rule.ID
+	if updatedRule.Match == "" {
+		updatedRule.Match = config.EligibilityMatchAll
+	}
+
+	if err := validateEligibilityRule(updatedRule); err != nil {
+		return err
+	}
+
+	if err := db.Save(&updatedRule).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(contextUser(c).ID, "Update eligibility rule", strconv.Itoa(rule.ID))
+
+	return c.JSON(http.StatusOK, updatedRule)
+}
+
+func DeleteEligibilityRule(c echo.Context) error {
+	db := config.DB()
+
+	ruleId, err := strconv.Atoi(c.Request().Header.Get("ruleId"))
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "ruleId"})
+	}
+
+	res := db.Delete(&config.EligibilityRule{}, ruleId)
+	if res.Error != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", res.Error)
+	} else if res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	AddLog(contextUser(c).ID, "Delete eligibility rule", strconv.Itoa(ruleId))
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// saveEligibilityCheck сохраняет проверку вместе с исходом каждого правила
+func saveEligibilityCheck(check *config.EligibilityCheck, outcomes []ruleOutcome, rejects []string) error {
+	check.Eligible = len(rejects) == 0
+	check.Rejects, _ = json.Marshal(nonNilStrings(rejects))
+
+	for _, outcome := range outcomes {
+		values, _ := json.Marshal(nonNilStrings(outcome.Values))
+		outcomeRejects, _ := json.Marshal(nonNilStrings(outcome.Rejects))
+		check.Results = append(check.Results, config.EligibilityCheckResult{
+			RuleID:   outcome.Rule.ID,
+			Source:   outcome.Rule.Source,
+			Path:     outcome.Rule.Path,
+			Operator: outcome.Rule.Operator,
+			Value:    outcome.Rule.Value,
+			Match:    outcome.Rule.Match,
+			WhenPath: outcome.Rule.WhenPath,
+			Message:  outcome.Rule.Message,
+			Applied:  outcome.Applied,
+			Passed:   outcome.Passed,
+			Values:   values,
+			Rejects:  outcomeRejects,
+		})
+	}
+
+	// Create сохраняет и Results одной транзакцией
+	return config.DB().Create(check).Error
+}
+
+func nonNilStrings(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// canReadEligibilityCheck — историю своих компаний видит пользователь, любой — право eligibilityCheck:read
+func canReadEligibilityCheck(user config.User, inn string) bool {
+	if (user.CompanyINN != "" && user.CompanyINN == inn) || userCan(user, config.PermEligibilityChecksRead) {
+		return true
+	}
+
+	var company config.Company
+	res := config.DB().Where(config.Company{INN: inn}).Limit(1).Find(&company)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false
+	}
+	_, ok := userCompanyRole(user, company)
+	return ok
+}
+
+func ListEligibilityChecks(c echo.Context) error {
+	db := config.DB()
+
+	user := contextUser(c)
+	inn := c.Request().Header.Get("inn")
+	if inn == "" {
+		company, err := activeCompany(c)
+		if err != nil {
+			return err
+		}
+		inn = company.INN
+	}
+	if !canReadEligibilityCheck(user, inn) {
+		return permissionDenied(user)
+	}
+
+	// Данные ZCB отдаются только в getEligibilityCheck — в списке они слишком тяжёлые
+	var checks []config.EligibilityCheck
+	err := db.Omit("card_data", "fssp_data", "fns_data").
+		Where(config.EligibilityCheck{INN: inn}).
+		Order("id desc").Limit(100).
+		Find(&checks).Error
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, checks)
+}
+
+func GetEligibilityCheck(c echo.Context) error {
+	db := config.DB()
+
+	checkId, err := strconv.Atoi(c.Request().Header.Get("checkId"))
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "checkId"})
+	}
+
+	var check config.EligibilityCheck
+	if err := db.Preload("Results", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id")
+	}).First(&check, checkId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if !canReadEligibilityCheck(contextUser(c), check.INN) {
+		return permissionDenied(contextUser(c))
+	}
+
+	return c.JSON(http.StatusOK, check)
+}
@@ -4,15 +4,14 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
-	minio2 "github.com/minio/minio-go/v7"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"io"
 	"io/ioutil"
 	"log"
@@ -23,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -30,17 +30,17 @@ import (
 	"github.com/nguyenthenguyen/docx"
 )
 
-func autoFill(userId string, sampleId string) error {
-	minioClient := config.MinioClient()
+func autoFill(dir string) error {
+	storage := config.Storage()
 
 	// Загружаем personalData.json
-	personalData, err := getPersonalData(minioClient, userId, sampleId)
+	personalData, err := getPersonalData(storage, dir)
 	if err != nil {
 		return err
 	}
 
 	// Получаем список .docx файлов
-	docFiles, err := listDocxFiles(minioClient, userId, sampleId)
+	docFiles, err := listDocxFiles(storage, dir)
 	if err != nil {
 		return err
 	}
@@ -48,7 +48,7 @@ func autoFill(userId string, sampleId string) error {
 	// Обрабатываем каждый .docx
 	for _, fileName := range docFiles {
 		// Загружаем шаблон документа
-		docBuffer, err := getDocumentTemplate(minioClient, fileName)
+		docBuffer, err := getDocumentTemplate(storage, fileName)
 		if err != nil {
 			return err
 			//println("Ошибка загрузки %s: %v", fileName, err)
@@ -61,28 +61,16 @@ func autoFill(userId string, sampleId string) error {
 			return err
 		}
 
-
 		// Конвертируем заполненный документ в PDF
-		pdfBufferStr := LibreSendToQueueSync(func() string {
-			buf, err := convertDocxToPDF(filledDocBuffer)
-			if err != nil {
-				log.Println("LibreOffice convert error:", err)
-				return ""
-			}
-			return base64.StdEncoding.EncodeToString(buf.Bytes())
-		})
-		if pdfBufferStr == "" {
+		pdfBytes := LibreSendToQueueSync(filledDocBuffer.Bytes())
+		if pdfBytes == nil {
 			return fmt.Errorf("failed to convert PDF via LibreOffice queue")
 		}
-		decodedPDF, err := base64.StdEncoding.DecodeString(pdfBufferStr)
-		if err != nil {
-			return fmt.Errorf("base64 decode failed: %v", err)
-		}
-		pdfBuffer := bytes.NewBuffer(decodedPDF)
+		pdfBuffer := bytes.NewBuffer(pdfBytes)
 
-		// Сохраняем PDF в MinIO
+		// Сохраняем PDF в хранилище
 		newFileName := strings.TrimSuffix(fileName, ".docx") + ".pdf"
-		err = saveFileToMinio(minioClient, pdfBuffer, userId, sampleId, path.Base(newFileName))
+		err = saveFileToStorage(storage, pdfBuffer, dir, path.Base(newFileName))
 		if err != nil {
 			return err
 		}
@@ -148,13 +136,11 @@ func convertDocxToPDF(docxBuffer *bytes.Buffer) (*bytes.Buffer, error) {
 	return bytes.NewBuffer(fileData), nil
 }
 
-func findRequiredFields(accessToken string, sampleId string) error {
-	minioClient := config.MinioClient()
-
-	user := getUserObject(accessToken)
+func findRequiredFields(dir string) error {
+	storage := config.Storage()
 
 	// Загружаем список .docx файлов
-	docFiles, err := listDocxFiles(minioClient, strconv.Itoa(user.ID), sampleId)
+	docFiles, err := listDocxFiles(storage, dir)
 	if err != nil {
 		return err
 	}
@@ -164,7 +150,7 @@ func findRequiredFields(accessToken string, sampleId string) error {
 
 	// Обрабатываем каждый файл, найдя все ключи в формате `{{ключ}}`
 	for _, fileName := range docFiles {
-		docBuffer, err := getDocumentTemplate(minioClient, fileName)
+		docBuffer, err := getDocumentTemplate(storage, fileName)
 		if err != nil {
 			return err
 		}
@@ -191,16 +177,13 @@ func findRequiredFields(accessToken string, sampleId string) error {
 		return err
 	}
 
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-
-	objectName := fmt.Sprintf("requiredFields.json", user.ID, sampleId)
-	_, err = minioClient.PutObject(
+	objectName := dir + "requiredFields.json"
+	err = storage.PutObject(
 		context.Background(),
-		bucketName,
 		objectName,
 		bytes.NewReader(jsonData),
 		int64(len(jsonData)),
-		minio2.PutObjectOptions{ContentType: "application/json"},
+		"application/json",
 	)
 	if err != nil {
 		return err
@@ -209,13 +192,12 @@ func findRequiredFields(accessToken string, sampleId string) error {
 	return nil
 }
 
-func saveFilledFields(userId, sampleId string, filledFields map[string]interface{}) error {
-	minioClient := config.MinioClient()
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
+func saveFilledFields(dir string, filledFields map[string]interface{}) error {
+	storage := config.Storage()
 
 	// Загружаем requiredFields.json
-	requiredFieldsPath := fmt.Sprintf("requiredFields.json", userId, sampleId)
-	obj, err := minioClient.GetObject(context.Background(), bucketName, requiredFieldsPath, minio2.GetObjectOptions{})
+	requiredFieldsPath := dir + "requiredFields.json"
+	obj, err := storage.GetObject(context.Background(), requiredFieldsPath)
 	if err != nil {
 		return fmt.Errorf("Ошибка загрузки requiredFields.json: %v", err)
 	}
@@ -231,6 +213,8 @@ func saveFilledFields(userId, sampleId string, filledFields map[string]interface
 		return fmt.Errorf("Ошибка разбора requiredFields.json: %v", err)
 	}
 
+	aiFields := make(map[string]string)
+
 	for key := range requiredFields {
 
 		val, exists := filledFields[key]
@@ -248,6 +232,7 @@ func saveFilledFields(userId, sampleId string, filledFields map[string]interface
 		}
 
 		requiredFields[key] = strVal
+		aiFields[key] = strVal
 	}
 
 	// Сохраняем обновленный requiredFields.json
@@ -256,100 +241,152 @@ func saveFilledFields(userId, sampleId string, filledFields map[string]interface
 		return fmt.Errorf("Ошибка сериализации updatedRequiredFields.json: %v", err)
 	}
 
-	_, err = minioClient.PutObject(
+	err = storage.PutObject(
 		context.Background(),
-		bucketName,
 		requiredFieldsPath,
 		bytes.NewReader(updatedRequiredFieldsData),
 		int64(len(updatedRequiredFieldsData)),
-		minio2.PutObjectOptions{ContentType: "application/json"},
+		"application/json",
 	)
 	if err != nil {
 		return fmt.Errorf("Ошибка сохранения обновленного requiredFields.json: %v", err)
 	}
 
+	// Отдельно запоминаем, что именно заполнил ИИ, — requiredFields.json потом правит пользователь
+	aiFieldsData, err := json.MarshalIndent(aiFields, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации aiFields.json: %v", err)
+	}
+
+	err = storage.PutObject(
+		context.Background(),
+		dir+"aiFields.json",
+		bytes.NewReader(aiFieldsData),
+		int64(len(aiFieldsData)),
+		"application/json",
+	)
+	if err != nil {
+		return fmt.Errorf("Ошибка сохранения aiFields.json: %v", err)
+	}
+
 	return nil
 }
 
 // ----------MAIN FUNC----------
 func FindRequiredFieldsAI(c echo.Context) error {
 	db := config.DB()
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 	if sampleId == "" || user.ID == 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Некорректные параметры"})
+		return NewAPIError(http.StatusBadRequest, "invalid_params")
 	}
 
-	var userSample config.UserSample
 	sampleIdInt, _ := strconv.Atoi(sampleId)
-	res := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-	if res.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "no sample ID found"})
-	} else if userSample.Status == "awaitAI" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "awaiting results"})
-	} else if userSample.Status != "startAI" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "incorrect stage"})
-	} else {
-		userSample.Status = "awaitAI"
-		db.Save(&userSample)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusInternalServerError, "user_sample_not_found")
+	} else if err := sampleGrantNotOpen(db, userSample.SampleID); err != nil {
+		return err
+	} else if sampleStatusOf(userSample) == StatusAwaitAI {
+		return NewAPIError(http.StatusBadRequest, "awaiting_ai")
+	} else if err := requireSampleStatus(userSample, StatusStartAI); err != nil {
+		return wrapError(http.StatusBadRequest, "invalid_step", err)
+	} else if err := checkApplicationQuota(db, company.ID, user, userSample.ID); err != nil {
+		return err
+	} else if err := transitionUserSample(db, &userSample, StatusAwaitAI, user.ID, "AI extraction started"); err != nil {
+		return wrapError(http.StatusBadRequest, "invalid_step", err)
 	}
 	// Run processing in background
-	AISendToQueueAsync(func() []byte {
-		processFindRequiredFieldsAI(db, accessToken, sampleId, user)
-		return nil
-	})
+	job, err := AISendToQueueAsync(user.ID, userSample)
+	if err != nil {
+		transitionUserSample(db, &userSample, StatusStartAI, 0, "failed to enqueue AI extraction")
+		return NewAPIError(http.StatusInternalServerError, "queue_error").WithCause(err)
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{"message": "Обработка запущена", "jobId": job.ID})
+}
+
+// RetryFindRequiredFieldsAI повторно запускает извлечение полей для заявки в состоянии failedAI
+func RetryFindRequiredFieldsAI(c echo.Context) error {
+	db := config.DB()
+	sampleId := c.Request().Header.Get("sampleId")
+	user := contextUser(c)
+	if sampleId == "" || user.ID == 0 {
+		return NewAPIError(http.StatusBadRequest, "invalid_params")
+	}
+
+	sampleIdInt, _ := strconv.Atoi(sampleId)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusInternalServerError, "user_sample_not_found")
+	} else if err := sampleGrantNotOpen(db, userSample.SampleID); err != nil {
+		return err
+	} else if err := requireSampleStatus(userSample, StatusFailedAI); err != nil {
+		return wrapError(http.StatusBadRequest, "invalid_step", err)
+	} else if err := checkApplicationQuota(db, company.ID, user, userSample.ID); err != nil {
+		return err
+	} else if err := transitionUserSample(db, &userSample, StatusAwaitAI, user.ID, "AI extraction retried"); err != nil {
+		return wrapError(http.StatusBadRequest, "invalid_step", err)
+	}
+
+	job, err := AISendToQueueAsync(user.ID, userSample)
+	if err != nil {
+		transitionUserSample(db, &userSample, StatusFailedAI, 0, "failed to enqueue AI extraction")
+		return NewAPIError(http.StatusInternalServerError, "queue_error").WithCause(err)
+	}
 
-	return c.JSON(http.StatusAccepted, map[string]string{"message": "Обработка запущена"})
+	return c.JSON(http.StatusAccepted, map[string]interface{}{"message": "Обработка запущена повторно", "jobId": job.ID})
 }
 
 // ProcessFindRequiredFieldsAI performs the full required fields AI processing logic.
-func processFindRequiredFieldsAI(db *gorm.DB, accessToken, sampleId string, user config.User) {
+// Прогресс пишется в задачу jobId, причина неудачи возвращается как *JobFailure.
+func processFindRequiredFieldsAI(db *gorm.DB, jobId int, userSample config.UserSample, user config.User) (failure error) {
+	sampleId := strconv.Itoa(userSample.SampleID)
+	dir := userSampleDir(userSample)
+
+	// При неудаче заявка переводится в failedAI обработчиком markFindRequiredFieldsAIFailed,
+	// когда задача окончательно провалится
 	defer func() {
 		if r := recover(); r != nil {
-			// Try to set status to doneAI if panic occurs
-			var userSample config.UserSample
-			sampleIdInt, _ := strconv.Atoi(sampleId)
-			db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-			userSample.Status = "doneAI"
-			db.Save(&userSample)
+			failure = &JobFailure{Code: "panic", Reason: fmt.Sprintf("%v", r)}
 		}
 	}()
 
-	minioClient := config.MinioClient()
-	var userSample config.UserSample
-	sampleIdInt, _ := strconv.Atoi(sampleId)
-	db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
+	storage := config.Storage()
 
 	// Step 1: Find required fields
-	err := findRequiredFields(accessToken, sampleId)
+	reportJobStep(jobId, jobStepFindRequiredFields, 0, 0, "")
+	err := findRequiredFields(dir)
 	if err != nil {
-		userSample.Status = "doneAI"
-		db.Save(&userSample)
-		return
+		return &JobFailure{Code: "required_fields_failed", Reason: err.Error()}
 	}
 
 	// Step 2: OCR
 	var ocrResults []string
-	pdfFiles, err := listPdfFiles(minioClient, strconv.Itoa(user.ID), sampleId)
+	pdfFiles, err := listPdfFiles(storage, dir)
 	if err != nil {
-		userSample.Status = "doneAI"
-		db.Save(&userSample)
-		return
+		return &JobFailure{Code: "storage_failed", Reason: err.Error()}
 	}
 	for _, fileName := range pdfFiles {
-		pdfBuffer, err := getPdfFromMinio(minioClient, fileName)
+		reportJobStep(jobId, jobStepOCR, 0, 0, path.Base(fileName))
+		pages, err := pdfPageCount(storage, fileName)
 		if err != nil {
-			userSample.Status = "doneAI"
-			db.Save(&userSample)
-			return
+			return &JobFailure{Code: "ocr_failed", Reason: "Не удалось прочитать файл " + path.Base(fileName)}
 		}
-		ocrText := OCRSendToQueueSync(func() []byte {
-			return scanOcr(pdfBuffer.Bytes()) // игнорируем ошибку
-		})
+		if err := checkUserSampleQuota(db, userSample.ID, user, config.UsageOCRPages, pages); err != nil {
+			return err
+		}
+		ocrText := OCRSendToQueueSync(fileName, user.ID, sampleId, userSample.ID, jobId)
 		if ocrText == nil {
-			userSample.Status = "doneAI"
-			db.Save(&userSample)
+			return &JobFailure{Code: "ocr_failed", Reason: "Не удалось распознать файл " + path.Base(fileName)}
 		}
 		ocrResults = append(ocrResults, string(ocrText))
 	}
@@ -373,11 +410,18 @@ func processFindRequiredFieldsAI(db *gorm.DB, accessToken, sampleId string, user
 
 	// Initialize rawFields before use
 	rawFields := make(map[string]interface{})
+	failedChunks := 0
 
-	for _, chunk := range chunks {
-		aiRes := GPTSendToQueueSync(func() string {
-			return aiRequest([]string{chunk}, user.ID, sampleId)
-		})
+	for i, chunk := range chunks {
+		reportJobStep(jobId, jobStepGPT, i+1, len(chunks), "")
+		if err := checkUserSampleQuota(db, userSample.ID, user, config.UsageAIRequests, 1); err != nil {
+			return err
+		}
+		aiRes := GPTSendToQueueSync(chunk, user.ID, sampleId, dir)
+		// Запрос списывается, только если YandexGPT ответил: задача без ответа завершается ошибкой
+		if aiRes != "" {
+			recordUserSampleUsage(db, userSample.ID, user.ID, config.UsageAIRequests, 1)
+		}
 		var outer struct {
 			Result struct {
 				Alternatives []struct {
@@ -390,16 +434,19 @@ func processFindRequiredFieldsAI(db *gorm.DB, accessToken, sampleId string, user
 
 		if err := json.Unmarshal([]byte(aiRes), &outer); err != nil {
 			log.Println("JSON parse error:", err)
+			failedChunks++
 			continue
 		}
 
 		if len(outer.Result.Alternatives) == 0 {
+			failedChunks++
 			continue
 		}
 
 		var partialFields map[string]interface{}
 		if err := json.Unmarshal([]byte(outer.Result.Alternatives[0].Message.Text), &partialFields); err != nil {
 			log.Println("inner JSON parse error:", err)
+			failedChunks++
 			continue
 		}
 
@@ -409,38 +456,60 @@ func processFindRequiredFieldsAI(db *gorm.DB, accessToken, sampleId string, user
 	}
 
 	if len(rawFields) == 0 {
-		userSample.Status = "doneAI"
-		db.Save(&userSample)
-		return
+		return &JobFailure{Code: "ai_empty_result", Reason: fmt.Sprintf("ИИ не вернул ни одного поля (неразобранных ответов: %d из %d)", failedChunks, len(chunks))}
 	}
 
 	// Step 4: Save  fields
+	reportJobStep(jobId, jobStepSaveFilledFields, 0, 0, "")
 	filledFields := make(map[string]interface{})
 	for key, value := range rawFields {
 		wrappedKey := "{{" + key + "}}"
 		filledFields[wrappedKey] = value
 	}
 
-	if err := saveFilledFields(strconv.Itoa(user.ID), sampleId, filledFields); err != nil {
-		userSample.Status = "doneAI"
-		db.Save(&userSample)
+	if err := saveFilledFields(dir, filledFields); err != nil {
+		return &JobFailure{Code: "save_fields_failed", Reason: err.Error()}
+	}
+
+	reportJobStep(jobId, jobStepDone, 0, 0, "")
+	// Заявку могли откатить, пока шла обработка, — тогда переход не выполнится, и это нормально
+	if transitionUserSample(db, &userSample, StatusDoneAI, 0, "AI extraction finished") == nil {
+		chargeUserSampleApplication(db, userSample.ID, user.ID)
+	}
+	return nil
+}
+
+// markFindRequiredFieldsAIFailed переводит заявку в failedAI после окончательной неудачи задачи извлечения полей
+func markFindRequiredFieldsAIFailed(job config.Job) {
+	db := config.DB()
+
+	var payload userSampleJobPayload
+	json.Unmarshal(job.Payload, &payload)
+
+	userSample, err := jobUserSample(db, job.UserID, payload)
+	if err != nil || sampleStatusOf(userSample) != StatusAwaitAI {
 		return
 	}
 
-	userSample.Status = "doneAI"
-	db.Save(&userSample)
+	reason := job.FailureCode
+	if job.FailureReason != "" {
+		reason += ": " + job.FailureReason
+	}
+	if err := transitionUserSample(db, &userSample, StatusFailedAI, 0, reason); err != nil {
+		fmt.Println("Не удалось перевести заявку в failedAI:", err)
+	}
 }
 
 // ----------MAIN FUNC----------
 
 // ----------AI FUNCS----------
 
-func aiRequest(docsInfo []string, userId int, sampleId string) string {
+func aiRequest(docsInfo []string, dir string) string {
 	apiURL := "https://llm.api.cloud.yandex.net/foundationModels/v1/completion"
 	folderId := os.Getenv("FOLDER_ID_YANDEX")
 	token, _ := updateIAMToken()
 
-	requiredFieldsBuffer := getSelectedFile(fmt.Sprintf("requiredFields.json", userId, sampleId))
+	requiredFieldsBuffer := getSelectedFile(dir + "requiredFields.json")
 
 	// Формируем messages
 	var messages []map[string]interface{}
@@ -504,7 +573,8 @@ func aiRequest(docsInfo []string, userId int, sampleId string) string {
 	return string(responseData)
 }
 
-func scanOcr(file []byte) []byte {
+// scanOcr распознаёт PDF постранично; onPage вызывается после каждой страницы (может быть nil)
+func scanOcr(file []byte, onPage func(page int, total int)) []byte {
 	// Разбиваем PDF на страницы
 
 	pages, err := splitPDFInMemory(file)
@@ -532,6 +602,9 @@ func scanOcr(file []byte) []byte {
 		cleanApiResponse = strings.ReplaceAll(cleanApiResponse, "\"", "")
 		// Добавляем результат в общий список
 		allResults = append(allResults, cleanApiResponse)
+		if onPage != nil {
+			onPage(i+1, len(pages))
+		}
 	}
 
 	// Преобразуем массив строк в JSON
@@ -550,11 +623,10 @@ func scanOcr(file []byte) []byte {
 // ----------AI FUNCS----------
 
 // Загружает personalData.json
-func getPersonalData(minioClient *minio2.Client, userId string, sampleId string) (map[string]string, error) {
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("requiredFields.json", userId, sampleId)
+func getPersonalData(storage config.ObjectStorage, dir string) (map[string]string, error) {
+	objectName := dir + "requiredFields.json"
 
-	obj, err := minioClient.GetObject(context.Background(), bucketName, objectName, minio2.GetObjectOptions{})
+	obj, err := storage.GetObject(context.Background(), objectName)
 	if err != nil {
 		return nil, err
 	}
@@ -574,11 +646,12 @@ func getPersonalData(minioClient *minio2.Client, userId string, sampleId string)
 	return personalData, nil
 }
 
-func preFill(message json.RawMessage, companyInfo json.RawMessage) (map[string]interface{}, error) {
+// preFill дополняет поля данными карточки компании; второй результат — ключи, найденные в карточке
+func preFill(message json.RawMessage, companyInfo json.RawMessage) (map[string]interface{}, map[string]bool, error) {
 	// 1. Разбираем входящий список полей
 	var fields map[string]interface{}
 	if err := json.Unmarshal(message, &fields); err != nil {
-		return nil, fmt.Errorf("ошибка разбора message: %w", err)
+		return nil, nil, fmt.Errorf("ошибка разбора message: %w", err)
 	}
 
 	// 2. Разбираем JSON с инфой о компании
@@ -588,12 +661,13 @@ func preFill(message json.RawMessage, companyInfo json.RawMessage) (map[string]i
 		} `json:"body"`
 	}
 	if err := json.Unmarshal(companyInfo, &companyData); err != nil {
-		return nil, fmt.Errorf("ошибка разбора companyInfo: %w", err)
+		return nil, nil, fmt.Errorf("ошибка разбора companyInfo: %w", err)
 	}
 	if len(companyData.Body.Docs) == 0 {
-		return nil, fmt.Errorf("companyInfo содержит пустой список docs")
+		return nil, nil, fmt.Errorf("companyInfo содержит пустой список docs")
 	}
 	company := companyData.Body.Docs[0] // Берем первый документ
+	found := make(map[string]bool)
 
 	// 3. Заполняем поля, если они есть в company (рекурсивный поиск)
 	for key := range fields {
@@ -620,35 +694,32 @@ func preFill(message json.RawMessage, companyInfo json.RawMessage) (map[string]i
 			return nil, false
 		}
 
-		if val, found := search(cleanKey, company); found {
+		if val, ok := search(cleanKey, company); ok {
 			if strVal, ok := val.(string); ok {
 				strVal = strings.ReplaceAll(strVal, `\"`, `"`)
 				fields[key] = strVal
 			} else {
 				fields[key] = val
 			}
+			found[key] = true
 		}
 	}
 
-	return fields, nil
+	return fields, found, nil
 }
 
 // ----------LIST FILES----------
 
-func listDocxFiles(minioClient *minio2.Client, userId, sampleId string) ([]string, error) {
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	prefix := fmt.Sprintf("filling/", userId, sampleId)
+func listDocxFiles(storage config.ObjectStorage, dir string) ([]string, error) {
+	prefix := dir + "filling/"
 
-	objectCh := minioClient.ListObjects(context.Background(), bucketName, minio2.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: true,
-	})
+	objects, err := storage.ListObjects(context.Background(), prefix, true)
+	if err != nil {
+		return nil, err
+	}
 
 	var docFiles []string
-	for object := range objectCh {
-		if object.Err != nil {
-			return nil, object.Err
-		}
+	for _, object := range objects {
 		if strings.HasSuffix(object.Key, ".docx") {
 			docFiles = append(docFiles, object.Key)
 		}
@@ -657,20 +728,16 @@ func listDocxFiles(minioClient *minio2.Client, userId, sampleId string) ([]strin
 	return docFiles, nil
 }
 
-func listPdfFiles(minioClient *minio2.Client, userId, sampleId string) ([]string, error) {
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	prefix := fmt.Sprintf("manualUploaded/", userId, sampleId)
+func listPdfFiles(storage config.ObjectStorage, dir string) ([]string, error) {
+	prefix := dir + "manualUploaded/"
 
-	objectCh := minioClient.ListObjects(context.Background(), bucketName, minio2.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: false,
-	})
+	objects, err := storage.ListObjects(context.Background(), prefix, false)
+	if err != nil {
+		return nil, err
+	}
 
 	var pdfFiles []string
-	for object := range objectCh {
-		if object.Err != nil {
-			return nil, object.Err
-		}
+	for _, object := range objects {
 		if strings.HasSuffix(object.Key, ".pdf") {
 			pdfFiles = append(pdfFiles, object.Key)
 		}
@@ -683,10 +750,8 @@ func listPdfFiles(minioClient *minio2.Client, userId, sampleId string) ([]string
 
 // ----------GET FILES----------
 
-func getDocumentTemplate(minioClient *minio2.Client, fileName string) (*bytes.Buffer, error) {
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-
-	obj, err := minioClient.GetObject(context.Background(), bucketName, fileName, minio2.GetObjectOptions{})
+func getDocumentTemplate(storage config.ObjectStorage, fileName string) (*bytes.Buffer, error) {
+	obj, err := storage.GetObject(context.Background(), fileName)
 	if err != nil {
 		return nil, err
 	}
@@ -700,10 +765,21 @@ func getDocumentTemplate(minioClient *minio2.Client, fileName string) (*bytes.Bu
 	return bytes.NewBuffer(data), nil
 }
 
-func getPdfFromMinio(minioClient *minio2.Client, fileName string) (*bytes.Buffer, error) {
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
+// pdfPageCount — число страниц PDF в хранилище, столько страниц OCR спишет его распознавание
+func pdfPageCount(storage config.ObjectStorage, fileName string) (int, error) {
+	pdfBuffer, err := getPdfFromStorage(storage, fileName)
+	if err != nil {
+		return 0, err
+	}
+	pages, err := splitPDFInMemory(pdfBuffer.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(pages), nil
+}
 
-	obj, err := minioClient.GetObject(context.Background(), bucketName, fileName, minio2.GetObjectOptions{})
+func getPdfFromStorage(storage config.ObjectStorage, fileName string) (*bytes.Buffer, error) {
+	obj, err := storage.GetObject(context.Background(), fileName)
 	if err != nil {
 		return nil, err
 	}
@@ -719,10 +795,9 @@ func getPdfFromMinio(minioClient *minio2.Client, fileName string) (*bytes.Buffer
 
 func getSelectedFile(fileName string) bytes.Buffer {
 
-	minioClient := config.MinioClient()
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
+	storage := config.Storage()
 
-	obj, err := minioClient.GetObject(context.Background(), bucketName, fileName, minio2.GetObjectOptions{})
+	obj, err := storage.GetObject(context.Background(), fileName)
 	if err != nil {
 		panic(fmt.Sprintf("Ошибка загрузки файла %s: %v", fileName, err))
 	}
@@ -784,21 +859,17 @@ func fillTemplate(docBuffer *bytes.Buffer, personalData map[string]string) (*byt
 	return outputBuffer, nil
 }
 
-// Сохраняет PDF в MinIO
-func saveFileToMinio(minioClient *minio2.Client, docxBuffer *bytes.Buffer, userId, sampleId, fileName string) error {
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("%s", userId, sampleId, strings.TrimPrefix(fileName, fmt.Sprintf("users/%s/samples/%s/", userId, sampleId)))
+// Сохраняет PDF в хранилище
+func saveFileToStorage(storage config.ObjectStorage, docxBuffer *bytes.Buffer, dir, fileName string) error {
+	objectName := dir + strings.TrimPrefix(fileName, dir)
 
-	_, err := minioClient.PutObject(
+	return storage.PutObject(
 		context.Background(),
-		bucketName,
 		objectName,
 		bytes.NewReader(docxBuffer.Bytes()),
 		int64(docxBuffer.Len()),
-		minio2.PutObjectOptions{},
+		"",
 	)
-
-	return err
 }
 
 // ----------DOCX EDITOR----------
@@ -901,76 +972,198 @@ func stripAllXMLExceptKeys(content string) string {
 
 // ----------FRONT VERSION----------
 
+// StartUserSample начинает заявку по шаблону для активной компании: создаёт UserSample вместе с привязкой
+// UserSampleCompany и копирует в неё шаблоны документов гранта. Если заявка уже есть, возвращает её
+func StartUserSample(c echo.Context) error {
+	db := config.DB()
+
+	user := contextUser(c)
+	if user.ID == 0 || user.IsSuspended {
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	sampleId, err := strconv.Atoi(c.Request().Header.Get("sampleId"))
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "sampleId"})
+	}
+
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	if err := sampleGrantNotOpen(db, sampleId); err != nil {
+		return err
+	}
+
+	var sample config.Sample
+	if err := db.First(&sample, sampleId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+	var grant config.Grant
+	if err := db.First(&grant, sample.GrantID).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	// Загрузить нужно документы, отмеченные в гранте как обязательные
+	toBeUploaded := []string{}
+	if len(grant.Documents) > 0 {
+		var documents map[string]bool
+		if err := json.Unmarshal(grant.Documents, &documents); err != nil {
+			return NewAPIError(http.StatusInternalServerError, "internal_error").WithCause(err)
+		}
+		for name, required := range documents {
+			if required {
+				toBeUploaded = append(toBeUploaded, name)
+			}
+		}
+		sort.Strings(toBeUploaded)
+	}
+	toBeUploadedRaw, _ := json.Marshal(toBeUploaded)
+
+	var userSample config.UserSample
+	created := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Блокировка пользователя не даёт параллельным запросам создать две заявки для одной компании
+		var locked config.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, user.ID).Error; err != nil {
+			return err
+		}
+
+		existing, err := findUserSample(tx, user.ID, company.ID, sampleId)
+		if err == nil {
+			userSample = existing
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		userSample = config.UserSample{UserID: user.ID, SampleID: sampleId, Status: string(StatusUpload), ToBeUploaded: toBeUploadedRaw}
+		if err := tx.Create(&userSample).Error; err != nil {
+			return err
+		}
+		created = true
+		return tx.Create(&config.UserSampleCompany{UserSampleID: userSample.ID, CompanyID: company.ID}).Error
+	})
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	if !created {
+		return c.JSON(http.StatusOK, userSample)
+	}
+
+	if err := copyGrantTemplates(grant.ID, userSampleDir(userSample)); err != nil {
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
+	}
+
+	AddLog(user.ID, "Start UserSample", strconv.Itoa(userSample.ID)+": "+company.INN)
+
+	return c.JSON(http.StatusOK, userSample)
+}
+
+// copyGrantTemplates копирует .docx гранта в каталог filling/ заявки — их заполняет autoFill
+func copyGrantTemplates(grantId int, dir string) error {
+	storage := config.Storage()
+	ctx := context.Background()
+	prefix := "grant/" + strconv.Itoa(grantId) + "/"
+
+	objects, err := storage.ListObjects(ctx, prefix, true)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if !strings.HasSuffix(strings.ToLower(object.Key), ".docx") {
+			continue
+		}
+		obj, err := storage.GetObject(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(obj)
+		obj.Close()
+		if err != nil {
+			return err
+		}
+		key := dir + "filling/" + path.Base(object.Key)
+		if err := storage.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)), object.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func ManualFileUpload(c echo.Context) error {
 	db := config.DB()
-	minioClient := config.MinioClient()
-	accessToken := c.Request().Header.Get("accessToken")
+	storage := config.Storage()
 	sampleId := c.Request().Header.Get("sampleId")
 	fileName := c.Request().Header.Get("fileName")
 
-	user := getUserObject(accessToken)
-	if accessToken == "" || sampleId == "" {
-		return c.JSON(400, map[string]string{"error": "accessToken и sampleId обязательны"})
+	user := contextUser(c)
+	if sampleId == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "sampleId"})
 	}
 
 	if user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	sampleIdInt, _ := strconv.Atoi(sampleId)
+
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusFailedDependency, "user_sample_not_found")
+	} else if err := sampleGrantNotOpen(db, userSample.SampleID); err != nil {
+		return err
 	}
 
 	// Получаем файл из запроса
 	file, err := c.FormFile("file")
 	if err != nil {
-		return c.JSON(400, map[string]string{"error": "Файл обязателен", "error_description": err.Error()})
+		return NewAPIError(http.StatusBadRequest, "file_required").WithCause(err)
 	}
 
 	// Проверяем, что файл имеет расширение .pdf
 	if !strings.HasSuffix(strings.ToLower(file.Filename), ".pdf") {
-		return c.JSON(400, map[string]string{"error": "Допускаются только файлы .pdf"})
+		return NewAPIError(http.StatusBadRequest, "invalid_file_type").WithDetails(map[string]string{"allowed": ".pdf"})
 	}
 
 	// Открываем файл
 	src, err := file.Open()
 	if err != nil {
-		return c.JSON(500, map[string]string{"error": "Ошибка при открытии файла"})
+		return NewAPIError(http.StatusInternalServerError, "storage_error")
 	}
 	defer src.Close()
 
 	// Читаем содержимое файла в память
 	fileBuffer := new(bytes.Buffer)
 	if _, err := io.Copy(fileBuffer, src); err != nil {
-		return c.JSON(500, map[string]string{"error": "Ошибка при чтении файла"})
+		return NewAPIError(http.StatusInternalServerError, "storage_error")
 	}
 
 	// Генерируем путь сохранения
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("%s", user.ID, sampleId, file.Filename)
+	objectName := userSampleDir(userSample) + "manualUploaded/" + file.Filename
 
-	// Загружаем файл в MinIO
-	_, err = minioClient.PutObject(
+	// Загружаем файл в хранилище
+	err = storage.PutObject(
 		context.Background(),
-		bucketName,
 		objectName,
 		bytes.NewReader(fileBuffer.Bytes()),
 		int64(fileBuffer.Len()),
-		minio2.PutObjectOptions{ContentType: "application/pdf"},
+		"application/pdf",
 	)
 	if err != nil {
-		return c.JSON(500, map[string]string{"error": "Ошибка при загрузке файла в MinIO"})
-	}
-
-	sampleIdInt, err := strconv.Atoi(sampleId)
-
-	var userSample config.UserSample
-	errUserSample := db.Where(config.UserSample{SampleID: sampleIdInt, UserID: user.ID}).First(&userSample)
-	if errUserSample.Error != nil || errUserSample.RowsAffected == 0 {
-		return c.JSON(http.StatusFailedDependency, err)
+		return NewAPIError(http.StatusInternalServerError, "storage_error")
 	}
 
 	// Преобразуем ToBeUploaded из JSON в []string
 	var toBeUploaded []string
 	if len(userSample.ToBeUploaded) > 0 {
 		if err := json.Unmarshal(userSample.ToBeUploaded, &toBeUploaded); err != nil {
-			return c.JSON(500, map[string]string{"error": "Ошибка чтения ToBeUploaded: " + err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "internal_error").WithCause(err)
 		}
 	}
 
@@ -984,67 +1177,68 @@ func ManualFileUpload(c echo.Context) error {
 	// Сохраняем обратно в RawMessage
 	newRaw, err := json.Marshal(updatedFiles)
 	if err != nil {
-		return c.JSON(500, map[string]string{"error": "Ошибка сохранения ToBeUploaded: " + err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "internal_error").WithCause(err)
 	}
 	userSample.ToBeUploaded = newRaw
-	if len(userSample.ToBeUploaded) == 2 {
-		userSample.Status = "startAI"
+	db.Model(&userSample).Update("to_be_uploaded", userSample.ToBeUploaded)
+	if len(userSample.ToBeUploaded) == 2 && sampleStatusOf(userSample) == StatusUpload {
+		transitionUserSample(db, &userSample, StatusStartAI, user.ID, "all documents uploaded")
 	}
-	db.Save(&userSample)
 
 	return c.JSON(200, map[string]string{"message": "Файл успешно загружен", "filePath": objectName})
 }
 
 func GetFieldsToFill(c echo.Context) error {
-	db := config.DB()
-	minioClient := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
 
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 
 	if user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Пользователь не найден или заблокирован"})
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
 	if sampleId == "" {
-		return c.JSON(http.StatusForbidden, nil)
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "sampleId"})
 	}
 
-	userId := strconv.Itoa(user.ID)
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("requiredFields.json", userId, sampleId)
-
-	obj, err := minioClient.GetObject(context.Background(), bucketName, objectName, minio2.GetObjectOptions{})
+	sampleIdInt, _ := strconv.Atoi(sampleId)
+	company, err := activeCompany(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка получения файла из MinIO", "description": err.Error()})
+		return err
+	}
+	userSample, errUserSample := findUserSample(config.DB(), user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusForbidden, "user_sample_not_found")
+	}
+
+	dir := userSampleDir(userSample)
+	objectName := dir + "requiredFields.json"
+
+	obj, err := storage.GetObject(context.Background(), objectName)
+	if errors.Is(err, config.ErrObjectNotFound) {
+		// Если файл не найден, возвращаем пустой список
+		return c.JSON(http.StatusOK, []string{})
+	} else if err != nil {
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 	defer obj.Close()
 
 	data, err := io.ReadAll(obj)
 	if err != nil {
-		if strings.Contains(err.Error(), "The specified key does not exist.") {
-			// Если файл не найден, возвращаем пустой список
-			return c.JSON(http.StatusOK, []string{})
-		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка чтения файла", "description": err.Error()})
-	}
-
-	var company config.Company
-	errCompany := db.Where(config.Company{INN: user.CompanyINN}).First(&company)
-	if errCompany.Error != nil || errCompany.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, nil)
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
 	var filledFields map[string]interface{}
-	filledFields, err = preFill(data, company.CardData)
+	var companyFields map[string]bool
+	filledFields, companyFields, err = preFill(data, company.CardData)
 
 	if _, ok := filledFields["{{ФИО}}"]; ok {
 		filledFields["{{ФИО}}"] = user.FullName
 	}
 	if _, ok := filledFields["{{ОГРН}}"]; ok {
-		filledFields["{{ОГРН}}"] = user.CompanyOGRN
+		filledFields["{{ОГРН}}"] = company.OGRN
 	}
 	if _, ok := filledFields["{{Должность}}"]; ok {
 		filledFields["{{Должность}}"] = "Генеральный Директор"
@@ -1060,30 +1254,35 @@ func GetFieldsToFill(c echo.Context) error {
 	}
 	if _, ok := filledFields["{{ПолнНаимОПФ}}"]; ok {
 		filledFields["{{ПолнНаимОПФ}}"] = slice(company.CardData, "$.body.docs.0.ПолнНаимОПФ")
+		companyFields["{{ПолнНаимОПФ}}"] = true
 	}
 
 	if _, ok := filledFields["{{ФО2024}}"]; ok {
 		fo := slice(company.CardData, "$.body.docs.0.ФО2024.ВЫРУЧКА")
 		if fo != "" && fo != "null" {
 			filledFields["{{ФО2024}}"] = fo
+			companyFields["{{ФО2024}}"] = true
 		}
 	}
 	if _, ok := filledFields["{{ФО2023}}"]; ok {
 		fo := slice(company.CardData, "$.body.docs.0.ФО2023.ВЫРУЧКА")
 		if fo != "" && fo != "null" {
 			filledFields["{{ФО2023}}"] = fo
+			companyFields["{{ФО2023}}"] = true
 		}
 	}
 	if _, ok := filledFields["{{ФО2022}}"]; ok {
 		fo := slice(company.CardData, "$.body.docs.0.ФО2022.ВЫРУЧКА")
 		if fo != "" && fo != "null" {
 			filledFields["{{ФО2022}}"] = fo
+			companyFields["{{ФО2022}}"] = true
 		}
 	}
 	if _, ok := filledFields["{{ФО2021}}"]; ok {
 		fo := slice(company.CardData, "$.body.docs.0.ФО2021.ВЫРУЧКА")
 		if fo != "" && fo != "null" {
 			filledFields["{{ФО2021}}"] = fo
+			companyFields["{{ФО2021}}"] = true
 		}
 	}
 
@@ -1118,54 +1317,124 @@ func GetFieldsToFill(c echo.Context) error {
 	filledFields = uniqueFields
 
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка автозаполнения", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "fill_failed").WithCause(err)
+	}
+
+	// По запросу сообщаем, откуда взято каждое значение
+	if c.Request().Header.Get("withSources") == "true" {
+		aiFields, _ := getAIFields(storage, dir)
+		sources := make(map[string]string, len(filledFields))
+		for key, value := range filledFields {
+			sources[key] = fieldSource(key, value, aiFields, companyFields)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"fields":  filledFields,
+			"sources": sources,
+		})
 	}
 
 	// Возвращаем результат
 	return c.JSON(http.StatusOK, filledFields)
 }
 
+const (
+	fieldSourceAI      = "ai"
+	fieldSourceCompany = "company"
+	fieldSourceProfile = "profile"
+	fieldSourceAuto    = "auto"
+	fieldSourceManual  = "manual"
+	fieldSourceBlank   = "blank"
+)
+
+// Поля, которые GetFieldsToFill всегда берёт из профиля пользователя или вычисляет сам
+var profileFieldKeys = map[string]bool{
+	"{{ФИО}}": true, "{{Номер_телефона}}": true, "{{Адрес_электронной_почты}}": true, "{{Email}}": true, "{{ОГРН}}": true,
+}
+var autoFieldKeys = map[string]bool{
+	"{{Должность}}": true, "{{ТекущЧисло}}": true, "{{ТекущМесяц}}": true, "{{ТекущДата}}": true,
+}
+
+func fieldSource(key string, value interface{}, aiFields map[string]string, companyFields map[string]bool) string {
+	strVal, ok := value.(string)
+	if !ok {
+		strVal = fmt.Sprintf("%v", value)
+	}
+
+	switch {
+	case profileFieldKeys[key]:
+		return fieldSourceProfile
+	case autoFieldKeys[key]:
+		return fieldSourceAuto
+	case companyFields[key]:
+		return fieldSourceCompany
+	case value == nil || strings.TrimSpace(strVal) == "":
+		return fieldSourceBlank
+	}
+
+	// Значение ИИ, которое пользователь потом исправил, считаем введённым вручную
+	if aiValue, ok := aiFields[key]; ok && aiValue == strVal {
+		return fieldSourceAI
+	}
+	return fieldSourceManual
+}
+
+func getAIFields(storage config.ObjectStorage, dir string) (map[string]string, error) {
+	obj, err := storage.GetObject(context.Background(), dir+"aiFields.json")
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var aiFields map[string]string
+	err = json.Unmarshal(data, &aiFields)
+	return aiFields, err
+}
+
 func FillRequiredFields(c echo.Context) error {
 	db := config.DB()
-	minioClient := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
 	var fields map[string]interface{}
 	if err := json.NewDecoder(c.Request().Body).Decode(&fields); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":             "Некорректный JSON в теле запроса",
-			"error_description": err.Error(),
-		})
+		return NewAPIError(http.StatusBadRequest, "invalid_json").WithCause(err)
 	}
 
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 
 	if user.ID == 0 || user.IsSuspended {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Пользователь не найден или заблокирован"})
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
 	if sampleId == "" {
-		return c.JSON(http.StatusForbidden, nil)
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "sampleId"})
 	}
 
 	sampleIdInt, err := strconv.Atoi(sampleId)
-	var userSample config.UserSample
-	res := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-	if res.RowsAffected == 0 {
-		return c.JSON(http.StatusForbidden, nil)
-	} else if userSample.Status != "doneAI" && userSample.Status != "filling" {
-		return c.JSON(http.StatusForbidden, "invalid step")
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusForbidden, "user_sample_not_found")
+	} else if err := sampleGrantNotOpen(db, userSample.SampleID); err != nil {
+		return err
+	} else if err := requireSampleStatus(userSample, StatusDoneAI, StatusFilling); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
 	}
 
-	userId := strconv.Itoa(user.ID)
-
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("requiredFields.json", userId, sampleId)
+	dir := userSampleDir(userSample)
+	objectName := dir + "requiredFields.json"
 
 	// Загружаем существующий файл, если он есть
 	existingFields := make(map[string]interface{})
-	obj, err := minioClient.GetObject(context.Background(), bucketName, objectName, minio2.GetObjectOptions{})
+	obj, err := storage.GetObject(context.Background(), objectName)
 	if err == nil {
 		defer obj.Close()
 		data, err := io.ReadAll(obj)
@@ -1176,7 +1445,7 @@ func FillRequiredFields(c echo.Context) error {
 
 	// Загружаем requiredFields.json для проверки допустимых ключей
 	allowedFields := make(map[string]interface{})
-	requiredFieldsObj, err := minioClient.GetObject(context.Background(), bucketName, fmt.Sprintf("users/%s/samples/%s/requiredFields.json", userId, sampleId), minio2.GetObjectOptions{})
+	requiredFieldsObj, err := storage.GetObject(context.Background(), objectName)
 	if err == nil {
 		defer requiredFieldsObj.Close()
 		requiredFieldsData, _ := io.ReadAll(requiredFieldsObj)
@@ -1204,110 +1473,103 @@ func FillRequiredFields(c echo.Context) error {
 			strVal = fmt.Sprintf("%v", value)
 		}
 		if strings.TrimSpace(strVal) == "" {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{
-				"error":          "Не все обязательные поля заполнены",
-				"missing_key":    key,
-				"fields":         newFields,
-				"existingFields": existingFields,
-				"value":          value,
-			})
+			return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": key})
 		}
 	}
 
 	// Сохраняем обратно
 	dataToWrite, err := json.MarshalIndent(existingFields, "", "  ")
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сериализации JSON", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "internal_error").WithCause(err)
 	}
 
-	_, err = minioClient.PutObject(
+	err = storage.PutObject(
 		context.Background(),
-		bucketName,
 		objectName,
 		bytes.NewReader(dataToWrite),
 		int64(len(dataToWrite)),
-		minio2.PutObjectOptions{ContentType: "application/json"},
+		"application/json",
 	)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка сохранения JSON в MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
-	err = autoFill(userId, sampleId)
+	err = autoFill(dir)
 
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error while filling", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "fill_failed").WithCause(err)
 	}
 
-	userSample.Status = "filling"
-	db.Save(&userSample)
+	if err := transitionUserSample(db, &userSample, StatusFilling, user.ID, "fields filled"); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Данные успешно обновлены"})
 }
 
 func ConfirmFilling(c echo.Context) error {
 	db := config.DB()
-	user := getUserObject(c.Request().Header.Get("accessToken"))
+	user := contextUser(c)
 	sampleId, errConv := strconv.Atoi(c.Request().Header.Get("sampleId"))
 
 	if user.ID == 0 || user.IsSuspended {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
 	if errConv != nil {
-		return c.JSON(http.StatusForbidden, nil)
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "sampleId"})
 	}
 
-	var userSample config.UserSample
-	err := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleId}).First(&userSample).Error
+	company, err := activeCompany(c)
 	if err != nil {
-		return c.JSON(http.StatusForbidden, nil)
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleId)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusForbidden, "user_sample_not_found")
+	} else if err := sampleGrantNotOpen(db, userSample.SampleID); err != nil {
+		return err
 	}
 
-	if userSample.Status != "filling" {
-		return c.JSON(http.StatusForbidden, "invalid step")
+	if err := requireSampleStatus(userSample, StatusFilling); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
 	}
 
-	errFill := fillDocs(user, sampleId)
+	errFill := fillDocs(user, userSample)
 	if errFill != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error while filling", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "fill_failed").WithCause(errFill)
 	}
 
-	userSample.Status = "filled"
-	db.Save(&userSample)
+	if err := transitionUserSample(db, &userSample, StatusFilled, user.ID, "filling confirmed"); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
+	}
 
 	return c.JSON(http.StatusOK, nil)
 }
 
-func fillDocs(user config.User, sampleId int) error {
-	db := config.DB()
-	if user.ID == 0 || user.IsSuspended {
+func fillDocs(user config.User, userSample config.UserSample) error {
+	if user.ID == 0 || user.IsSuspended || userSample.UserID != user.ID {
 		return errors.New("unauth")
 	}
 
-	var userSample config.UserSample
-	err := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleId}).First(&userSample).Error
-	if err != nil {
-		return errors.New("err get userSample")
-	}
-
-	minioClient := config.MinioClient()
-	userIdStr := strconv.Itoa(user.ID)
+	storage := config.Storage()
+	dir := userSampleDir(userSample)
 
 	// 1. Получение всех .docx из filling/
-	docFiles, err := listDocxFiles(minioClient, userIdStr, strconv.Itoa(sampleId))
+	docFiles, err := listDocxFiles(storage, dir)
 	if err != nil {
 		return errors.New("err get files")
 	}
 
 	// 2. Получение requiredFields.json
-	requiredFields, err := getPersonalData(minioClient, userIdStr, strconv.Itoa(sampleId))
+	requiredFields, err := getPersonalData(storage, dir)
 	if err != nil {
 		return errors.New("err get requiredFields")
 	}
 
 	// 3. Обработка каждого docx
 	for _, fileName := range docFiles {
-		docBuffer, err := getDocumentTemplate(minioClient, fileName)
+		docBuffer, err := getDocumentTemplate(storage, fileName)
 		if err != nil {
 			return errors.New("err get document template")
 		}
@@ -1318,8 +1580,8 @@ func fillDocs(user config.User, sampleId int) error {
 			return errors.New("unauth")
 		}
 
-		// Сохранение обратно в MinIO
-		err = saveFileToMinio(minioClient, filledDocBuffer, userIdStr, strconv.Itoa(sampleId), fileName)
+		// Сохранение обратно в хранилище
+		err = saveFileToStorage(storage, filledDocBuffer, dir, fileName)
 		if err != nil {
 			return errors.New("err save file")
 		}
@@ -1330,44 +1592,42 @@ func fillDocs(user config.User, sampleId int) error {
 
 func GetZipPDFs(c echo.Context) error {
 	db := config.DB()
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
 
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 	if user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
-	var userSample config.UserSample
 	sampleIdInt, _ := strconv.Atoi(sampleId)
-	res := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-	if res.RowsAffected == 0 {
-		return c.JSON(http.StatusForbidden, nil)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
 	}
-	if userSample.Status != "filled" {
-		return c.JSON(http.StatusForbidden, "invalid step")
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusForbidden, "user_sample_not_found")
+	}
+	if err := requireSampleStatus(userSample, StatusFilled); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
 	}
 
-	minioClient := config.MinioClient()
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
+	storage := config.Storage()
 
-	userIdStr := strconv.Itoa(user.ID)
+	dir := userSampleDir(userSample)
 
 	// Собираем файлы из двух папок
-	fillingPrefix := fmt.Sprintf("filling/", userIdStr, sampleId)
-	manualUploadedPrefix := fmt.Sprintf("manualUploaded/", userIdStr, sampleId)
+	fillingPrefix := dir + "filling/"
+	manualUploadedPrefix := dir + "manualUploaded/"
 
 	var pdfFiles []string
 
 	for _, prefix := range []string{fillingPrefix, manualUploadedPrefix} {
-		objectCh := minioClient.ListObjects(context.Background(), bucketName, minio2.ListObjectsOptions{
-			Prefix:    prefix,
-			Recursive: true,
-		})
-		for object := range objectCh {
-			if object.Err != nil {
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": object.Err.Error()})
-			}
+		objects, err := storage.ListObjects(context.Background(), prefix, true)
+		if err != nil {
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
+		}
+		for _, object := range objects {
 			if strings.HasSuffix(strings.ToLower(object.Key), ".pdf") {
 				pdfFiles = append(pdfFiles, object.Key)
 			}
@@ -1375,7 +1635,7 @@ func GetZipPDFs(c echo.Context) error {
 	}
 
 	if len(pdfFiles) == 0 {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "No PDF files found"})
+		return NewAPIError(http.StatusNotFound, "files_not_found")
 	}
 
 	// Создаём ZIP архив в памяти
@@ -1383,29 +1643,29 @@ func GetZipPDFs(c echo.Context) error {
 	zipWriter := zip.NewWriter(&zipBuffer)
 
 	for _, fileKey := range pdfFiles {
-		obj, err := minioClient.GetObject(context.Background(), bucketName, fileKey, minio2.GetObjectOptions{})
+		obj, err := storage.GetObject(context.Background(), fileKey)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error fetching file: " + err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 		data, err := io.ReadAll(obj)
 		obj.Close()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error reading file: " + err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 
 		f, err := zipWriter.Create(filepath.Base(fileKey))
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error creating zip entry: " + err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 		}
 
 		_, err = f.Write(data)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error writing to zip: " + err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 		}
 	}
 
 	if err := zipWriter.Close(); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error closing zip: " + err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 	}
 
 	return c.Blob(http.StatusOK, "application/zip", zipBuffer.Bytes())
@@ -1413,144 +1673,146 @@ func GetZipPDFs(c echo.Context) error {
 
 func UploadSignedZip(c echo.Context) error {
 	db := config.DB()
-	minioClient := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
 
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 	if user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
-	var userSample config.UserSample
 	sampleIdInt, _ := strconv.Atoi(sampleId)
-	errUserSample := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-	if errUserSample.Error != nil || errUserSample.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, nil)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
 	}
-	if userSample.Status != "filled" {
-		return c.JSON(http.StatusForbidden, "invalid step")
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusInternalServerError, "user_sample_not_found")
+	} else if err := sampleGrantNotOpen(db, userSample.SampleID); err != nil {
+		return err
+	}
+	if err := requireSampleStatus(userSample, StatusFilled); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
 	}
 
 	// Получаем файл из запроса
 	file, err := c.FormFile("file")
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Файл обязателен", "description": err.Error()})
+		return NewAPIError(http.StatusBadRequest, "file_required").WithCause(err)
 	}
 
 	src, err := file.Open()
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при открытии файла", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 	defer src.Close()
 
 	fileBuffer := new(bytes.Buffer)
 	if _, err := io.Copy(fileBuffer, src); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при чтении файла", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
-	userIdStr := strconv.Itoa(user.ID)
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("signedFiles.zip", userIdStr, sampleId)
+	objectName := userSampleDir(userSample) + "signedFiles.zip"
 
-	_, err = minioClient.PutObject(
+	err = storage.PutObject(
 		context.Background(),
-		bucketName,
 		objectName,
 		bytes.NewReader(fileBuffer.Bytes()),
 		int64(fileBuffer.Len()),
-		minio2.PutObjectOptions{ContentType: "application/zip"},
+		"application/zip",
 	)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при загрузке в MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
-	userSample.Status = "signed"
-	db.Save(&userSample)
+	if err := transitionUserSample(db, &userSample, StatusSigned, user.ID, "signed archive uploaded"); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Файл успешно загружен"})
 }
 
 func GetReadyArchives(c echo.Context) error {
 	db := config.DB()
-	minioClient := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
 	typeArchive := c.Request().Header.Get("typeArchive")
 
 	if typeArchive != "pdf" && typeArchive != "docx" {
-		return c.JSON(http.StatusForbidden, "Invalid type archive")
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "typeArchive"})
 	}
 
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 	if user.ID == 0 || user.IsSuspended {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
 	if sampleId == "" {
-		return c.JSON(http.StatusForbidden, nil)
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "sampleId"})
 	}
 
-	var userSample config.UserSample
 	sampleIdInt, _ := strconv.Atoi(sampleId)
-
-	errUserSample := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-	if errUserSample.Error != nil || errUserSample.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, nil)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusInternalServerError, "user_sample_not_found")
 	}
 
-	if userSample.Status != "signed" {
-		return c.JSON(http.StatusForbidden, "invalid step")
+	if err := requireSampleStatus(userSample, StatusSigned); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
 	}
 
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("signedFiles.zip", user.ID, sampleId)
+	objectName := userSampleDir(userSample) + "signedFiles.zip"
 
-	obj, err := minioClient.GetObject(context.Background(), bucketName, objectName, minio2.GetObjectOptions{})
+	obj, err := storage.GetObject(context.Background(), objectName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при получении файла из MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 	defer obj.Close()
 	fileData, err := io.ReadAll(obj)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при чтении файла из MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
 	// Получаем все .docx файлы из filling/
-	docxFiles, err := listDocxFiles(minioClient, strconv.Itoa(user.ID), sampleId)
+	docxFiles, err := listDocxFiles(storage, userSampleDir(userSample))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при получении docx файлов", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
 	// Создаём ZIP архив из .docx файлов в памяти
 	var zipDocxBuffer bytes.Buffer
 	zipDocxWriter := zip.NewWriter(&zipDocxBuffer)
 	for _, fileKey := range docxFiles {
-		obj, err := minioClient.GetObject(context.Background(), bucketName, fileKey, minio2.GetObjectOptions{})
+		obj, err := storage.GetObject(context.Background(), fileKey)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при получении .docx файла из MinIO", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 		data, err := io.ReadAll(obj)
 		obj.Close()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при чтении .docx файла из MinIO", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 		f, err := zipDocxWriter.CreateHeader(&zip.FileHeader{
 			Name:   filepath.Base(fileKey),
 			Method: zip.Deflate,
 		})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при создании zip-вложения", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 		}
 		_, err = f.Write(data)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при записи zip-вложения", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 		}
 	}
 	if err := zipDocxWriter.Close(); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при закрытии zip-вложения", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 	}
 
 	if typeArchive == "pdf" {
@@ -1563,43 +1825,43 @@ func GetReadyArchives(c echo.Context) error {
 
 func PreviewFill(c echo.Context) error {
 	db := config.DB()
-	minioClient := config.MinioClient()
-	accessToken := c.Request().Header.Get("accessToken")
+	storage := config.Storage()
 	sampleId := c.Request().Header.Get("sampleId")
 	fileName := c.Request().Header.Get("fileName")
 
 	if fileName == "" || !strings.Contains(fileName, ".pdf") {
-		return c.JSON(http.StatusForbidden, "invalid file")
+		return NewAPIError(http.StatusBadRequest, "invalid_file_type").WithDetails(map[string]string{"allowed": ".pdf"})
 	}
 
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 	if user.ID == 0 || user.IsSuspended {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
-	var userSample config.UserSample
 	sampleIdInt, _ := strconv.Atoi(sampleId)
-
-	errUserSample := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-	if errUserSample.Error != nil || errUserSample.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, nil)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusInternalServerError, "user_sample_not_found")
 	}
 
-	if userSample.Status != "filling" && userSample.Status != "filled" {
-		return c.JSON(http.StatusForbidden, "invalid step")
+	if err := requireSampleStatus(userSample, StatusFilling, StatusFilled); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
 	}
 
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("", user.ID, sampleId, fileName)
+	objectName := userSampleDir(userSample) + fileName
 
-	obj, err := minioClient.GetObject(context.Background(), bucketName, objectName, minio2.GetObjectOptions{})
+	obj, err := storage.GetObject(context.Background(), objectName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при получении файла из MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 	defer obj.Close()
 	fileData, err := io.ReadAll(obj)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при чтении файла из MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
 	return c.Blob(http.StatusOK, "application/pdf", fileData)
@@ -1607,87 +1869,89 @@ func PreviewFill(c echo.Context) error {
 
 func MailSignedZip(c echo.Context) error {
 	db := config.DB()
-	minioClient := config.MinioClient()
+	storage := config.Storage()
 
-	accessToken := c.Request().Header.Get("accessToken")
 	sampleId := c.Request().Header.Get("sampleId")
-	user := getUserObject(accessToken)
+	user := contextUser(c)
 
 	if user.ID == 0 {
-		return c.JSON(http.StatusUnauthorized, nil)
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
 	}
 
-	var userSample config.UserSample
 	sampleIdInt, _ := strconv.Atoi(sampleId)
-
-	errUserSample := db.Where(config.UserSample{UserID: user.ID, SampleID: sampleIdInt}).First(&userSample)
-	if errUserSample.Error != nil || errUserSample.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, nil)
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, errUserSample := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if errUserSample != nil {
+		return NewAPIError(http.StatusInternalServerError, "user_sample_not_found")
+	} else if err := sampleGrantNotOpen(db, userSample.SampleID); err != nil {
+		return err
 	}
 
-	if userSample.Status != "signed" {
-		return c.JSON(http.StatusForbidden, "invalid step")
+	if err := requireSampleStatus(userSample, StatusSigned); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
 	}
 
-	bucketName, _ := os.LookupEnv("MINIO_BUCKET_NAME")
-	objectName := fmt.Sprintf("signedFiles.zip", user.ID, sampleId)
+	objectName := userSampleDir(userSample) + "signedFiles.zip"
 
-	obj, err := minioClient.GetObject(context.Background(), bucketName, objectName, minio2.GetObjectOptions{})
+	obj, err := storage.GetObject(context.Background(), objectName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при получении файла из MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 	defer obj.Close()
 
 	fileData, err := io.ReadAll(obj)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при чтении файла из MinIO", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
 	// Получаем все .docx файлы из filling/
-	docxFiles, err := listDocxFiles(minioClient, strconv.Itoa(user.ID), sampleId)
+	docxFiles, err := listDocxFiles(storage, userSampleDir(userSample))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при получении docx файлов", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 	}
 
 	// Создаём ZIP архив из .docx файлов в памяти
 	var zipDocxBuffer bytes.Buffer
 	zipDocxWriter := zip.NewWriter(&zipDocxBuffer)
 	for _, fileKey := range docxFiles {
-		obj, err := minioClient.GetObject(context.Background(), bucketName, fileKey, minio2.GetObjectOptions{})
+		obj, err := storage.GetObject(context.Background(), fileKey)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при получении .docx файла из MinIO", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 		data, err := io.ReadAll(obj)
 		obj.Close()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при чтении .docx файла из MinIO", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "storage_error").WithCause(err)
 		}
 		f, err := zipDocxWriter.CreateHeader(&zip.FileHeader{
 			Name:   filepath.Base(fileKey),
 			Method: zip.Deflate,
 		})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при создании zip-вложения", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 		}
 		_, err = f.Write(data)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при записи zip-вложения", "description": err.Error()})
+			return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 		}
 	}
 	if err := zipDocxWriter.Close(); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Ошибка при закрытии zip-вложения", "description": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "archive_error").WithCause(err)
 	}
 
 	var sample config.Sample
 	errSample := db.Where(config.Sample{ID: userSample.SampleID}).First(&sample)
 	if errSample.Error != nil || errSample.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, nil)
+		return NewAPIError(http.StatusInternalServerError, "not_found")
 	}
 
 	var grant config.Grant
 	errGrant := db.Where(config.Grant{ID: sample.GrantID}).First(&grant)
 	if errGrant.Error != nil || errGrant.RowsAffected == 0 {
-		return c.JSON(http.StatusInternalServerError, nil)
+		return NewAPIError(http.StatusInternalServerError, "not_found")
 	}
 
 	errMail := SendMail(user.Email, "Ваши документы готовы!", grant.Instruction, []struct {
@@ -1704,11 +1968,12 @@ func MailSignedZip(c echo.Context) error {
 		},
 	})
 	if errMail != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return NewAPIError(http.StatusInternalServerError, "mail_failed").WithCause(errMail)
 	}
 
-	userSample.Status = "sent"
-	db.Save(&userSample)
+	if err := transitionUserSample(db, &userSample, StatusSent, user.ID, "documents mailed"); err != nil {
+		return wrapError(http.StatusForbidden, "invalid_step", err)
+	}
 
 	return c.JSON(http.StatusOK, nil)
 }
@@ -0,0 +1,371 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"park/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	jobTypeGrantAlert          = "grantAlert"
+	grantAlertDigestPeriod     = 24 * time.Hour
+	grantAlertDigestPollPeriod = time.Hour
+	defaultPublicURL           = "https://fintechnik.online"
+)
+
+type grantAlertJobPayload struct {
+	GrantID int `json:"grantId"`
+}
+
+func init() {
+	RegisterJobHandler(jobTypeGrantAlert, func(job config.Job) ([]byte, error) {
+		var payload grantAlertJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, err
+		}
+		return nil, sendGrantAlerts(payload.GrantID)
+	})
+}
+
+// grantVisible — грант опубликован: о нём можно сообщать подписчикам
+func grantVisible(status string) bool {
+	return status == config.GrantStatusPublished || status == config.GrantStatusOpen
+}
+
+// enqueueGrantAlerts ставит в MailQueue рассылку о гранте, который только что опубликован
+func enqueueGrantAlerts(grantId int) {
+	if _, err := MailQueue.Enqueue(config.Job{Type: jobTypeGrantAlert}, grantAlertJobPayload{GrantID: grantId}); err != nil {
+		fmt.Println("Ошибка постановки рассылки о гранте:", err)
+	}
+}
+
+// grantAlertUnsubscribeURL — ссылка отписки для писем. Адрес сервиса задаётся PUBLIC_URL
+func grantAlertUnsubscribeURL(token string) string {
+	base, ok := os.LookupEnv("PUBLIC_URL")
+	if !ok || base == "" {
+		base = defaultPublicURL
+	}
+	return strings.TrimRight(base, "/") + apiV1Prefix + "/unsubscribeGrantAlertsByLink?token=" + url.QueryEscape(token)
+}
+
+// sendGrantAlertMail отправляет письмо о грантах со ссылкой отписки в тексте и в заголовках List-Unsubscribe:
+// почтовые клиенты отписывают одним нажатием, отправляя POST на ту же ссылку (RFC 8058)
+func sendGrantAlertMail(to string, subject string, text string, token string) error {
+	unsubscribeURL := grantAlertUnsubscribeURL(token)
+	return SendMailWithHeaders(to, subject, text+"\n\nОтписаться от уведомлений: "+unsubscribeURL, map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	})
+}
+
+func grantTitle(grant config.Grant, terms config.GrantTerms) string {
+	if terms.Title != "" {
+		return terms.Title
+	}
+	return "Грант №" + strconv.Itoa(grant.ID)
+}
+
+// grantAlertText — текст уведомления о гранте: условия и причины, по которым он подходит компании
+func grantAlertText(grant config.Grant, terms config.GrantTerms, company config.Company, match decreeMatch) string {
+	text := grantTitle(grant, terms) + " подходит компании с ИНН " + company.INN + ":\n- " + strings.Join(match.Reasons, "\n- ")
+	if terms.Description != "" {
+		text += "\n\n" + terms.Description
+	}
+	if terms.OpensAt != nil && terms.OpensAt.After(time.Now()) {
+		text += "\n\nПриём заявок начнётся " + terms.OpensAt.Format("02.01.2006")
+	}
+	if terms.Deadline != nil {
+		text += "\nПриём заявок до " + terms.Deadline.Format("02.01.2006")
+	}
+	return text
+}
+
+// sendGrantAlerts создаёт уведомления подписчикам, чьим компаниям подходит грант, и сразу пишет тем,
+// кто не выбрал дайджест. Повтор задачи не дублирует уведомления и письма
+func sendGrantAlerts(grantId int) error {
+	db := config.DB()
+
+	var grant config.Grant
+	if err := db.First(&grant, grantId).Error; err != nil {
+		return &JobFailure{Code: "not_found", Reason: err.Error()}
+	}
+	terms, err := grantTermsOf(db, grant.ID)
+	if err != nil {
+		return err
+	}
+	// Пока задача ждала очереди, грант могли вернуть в черновик
+	if !grantVisible(terms.Status) {
+		return nil
+	}
+
+	var decree config.Decree
+	if err := db.First(&decree, grant.DecreeID).Error; err != nil {
+		return &JobFailure{Code: "not_found", Reason: err.Error()}
+	}
+
+	var subscriptions []config.GrantAlertSubscription
+	if err := db.Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	companyIds := make([]int, 0, len(subscriptions))
+	userIds := make([]int, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		companyIds = append(companyIds, subscription.CompanyID)
+		userIds = append(userIds, subscription.UserID)
+	}
+
+	var companies []config.Company
+	if err := db.Where("id IN ?", companyIds).Find(&companies).Error; err != nil {
+		return err
+	}
+	matches := make(map[int]decreeMatch)
+	companyById := make(map[int]config.Company)
+	for _, company := range companies {
+		if match, ok := matchDecree(companyProfileOf(company.CardData), decree, true); ok {
+			matches[company.ID] = match
+			companyById[company.ID] = company
+		}
+	}
+
+	var users []config.User
+	if err := db.Where("id IN ?", userIds).Find(&users).Error; err != nil {
+		return err
+	}
+	userById := make(map[int]config.User)
+	for _, user := range users {
+		userById[user.ID] = user
+	}
+
+	for _, subscription := range subscriptions {
+		match, ok := matches[subscription.CompanyID]
+		if !ok {
+			continue
+		}
+		// Подписку могли не удалить, когда пользователь потерял доступ к компании
+		user, ok := userById[subscription.UserID]
+		if !ok {
+			continue
+		}
+		if _, ok := userCompanyRole(user, companyById[subscription.CompanyID]); !ok {
+			continue
+		}
+
+		notification := config.Notification{
+			UserID:    subscription.UserID,
+			CompanyID: subscription.CompanyID,
+			Kind:      config.NotificationGrantPublished,
+			GrantID:   grant.ID,
+			Title:     "Новый грант: " + grantTitle(grant, terms),
+			Text:      grantAlertText(grant, terms, companyById[subscription.CompanyID], match),
+		}
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 || subscription.Digest || user.Email == "" {
+			continue
+		}
+
+		if err := sendGrantAlertMail(user.Email, notification.Title, notification.Text, subscription.UnsubscribeToken); err != nil {
+			fmt.Println("Ошибка отправки уведомления о гранте:", err)
+			continue
+		}
+		db.Model(&notification).Update("emailed_at", time.Now())
+	}
+	return nil
+}
+
+// StartGrantAlertDigest раз в час отправляет дневные дайджесты подписчикам, у которых подошёл срок
+func StartGrantAlertDigest() {
+	go func() {
+		for {
+			sendGrantAlertDigests(time.Now())
+			time.Sleep(grantAlertDigestPollPeriod)
+		}
+	}()
+}
+
+func sendGrantAlertDigests(now time.Time) {
+	db := config.DB()
+
+	var subscriptions []config.GrantAlertSubscription
+	err := db.Where("digest AND (last_digest_at IS NULL OR last_digest_at <= ?)", now.Add(-grantAlertDigestPeriod)).
+		Find(&subscriptions).Error
+	if err != nil {
+		fmt.Println("Ошибка загрузки подписок на гранты:", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		// Отметка до отправки: другой экземпляр сервиса не отправит тот же дайджест
+		res := db.Model(&subscription).
+			Where("last_digest_at IS NULL OR last_digest_at <= ?", now.Add(-grantAlertDigestPeriod)).
+			Update("last_digest_at", now)
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+
+		if err := sendGrantAlertDigest(db, subscription); err != nil {
+			fmt.Println("Ошибка отправки дайджеста грантов:", err)
+		}
+	}
+}
+
+func sendGrantAlertDigest(db *gorm.DB, subscription config.GrantAlertSubscription) error {
+	var notifications []config.Notification
+	err := db.Where("user_id = ? AND company_id = ? AND kind = ? AND emailed_at IS NULL",
+		subscription.UserID, subscription.CompanyID, config.NotificationGrantPublished).
+		Order("id").Find(&notifications).Error
+	if err != nil || len(notifications) == 0 {
+		return err
+	}
+
+	var user config.User
+	if err := db.First(&user, subscription.UserID).Error; err != nil || user.Email == "" {
+		return err
+	}
+	var company config.Company
+	if err := db.First(&company, subscription.CompanyID).Error; err != nil {
+		return err
+	}
+	if _, ok := userCompanyRole(user, company); !ok {
+		return nil
+	}
+
+	texts := make([]string, 0, len(notifications))
+	ids := make([]int, 0, len(notifications))
+	for _, notification := range notifications {
+		texts = append(texts, notification.Text)
+		ids = append(ids, notification.ID)
+	}
+
+	subject := "Новые гранты для вашей компании: " + strconv.Itoa(len(notifications))
+	if err := sendGrantAlertMail(user.Email, subject, strings.Join(texts, "\n\n---\n\n"), subscription.UnsubscribeToken); err != nil {
+		return err
+	}
+
+	return db.Model(&config.Notification{}).Where("id IN ?", ids).Update("emailed_at", time.Now()).Error
+}
+
+func ListGrantAlertSubscriptions(c echo.Context) error {
+	var subscriptions []config.GrantAlertSubscription
+	err := config.DB().Where(config.GrantAlertSubscription{UserID: contextUser(c).ID}).Order("id").Find(&subscriptions).Error
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, subscriptions)
+}
+
+// SubscribeGrantAlerts подписывает пользователя на гранты для активной компании; повторный вызов меняет режим дайджеста
+func SubscribeGrantAlerts(c echo.Context) error {
+	db := config.DB()
+	user := contextUser(c)
+
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return NewAPIError(http.StatusInternalServerError, "internal_error").WithCause(err)
+	}
+
+	subscription := config.GrantAlertSubscription{
+		UserID:           user.ID,
+		CompanyID:        company.ID,
+		Digest:           c.Request().Header.Get("digest") == "true",
+		UnsubscribeToken: token,
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "company_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"digest"}),
+	}).Create(&subscription).Error
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(user.ID, "Subscribe GrantAlerts", company.INN)
+	return c.JSON(http.StatusOK, subscription)
+}
+
+func UnsubscribeGrantAlerts(c echo.Context) error {
+	user := contextUser(c)
+
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+
+	res := config.DB().Where(config.GrantAlertSubscription{UserID: user.ID, CompanyID: company.ID}).
+		Delete(&config.GrantAlertSubscription{})
+	if res.Error != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", res.Error)
+	} else if res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	AddLog(user.ID, "Unsubscribe GrantAlerts", company.INN)
+	return c.JSON(http.StatusOK, nil)
+}
+
+// Страница подтверждения отписки. Переход по ссылке ничего не меняет: ссылки из писем открывают и сканеры
+// почтовых серверов, поэтому отписка выполняется только отправкой формы
+const grantAlertUnsubscribePage = `<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Отписка от уведомлений</title></head>
+<body>
+<p>Отписаться от уведомлений о новых грантах?</p>
+<form method="post" action="?token=%s"><button type="submit">Отписаться</button></form>
+</body>
+</html>`
+
+// ConfirmUnsubscribeGrantAlerts — переход по ссылке из письма, без входа: показывает страницу подтверждения.
+// Ссылку открывает браузер, поэтому токен передаётся в параметре запроса, а не в заголовке
+func ConfirmUnsubscribeGrantAlerts(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "token"})
+	}
+
+	return c.HTML(http.StatusOK, fmt.Sprintf(grantAlertUnsubscribePage, html.EscapeString(url.QueryEscape(token))))
+}
+
+// UnsubscribeGrantAlertsByToken отписывает по токену из ссылки: форма страницы подтверждения или
+// One-Click POST почтового клиента (RFC 8058)
+func UnsubscribeGrantAlertsByToken(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "token"})
+	}
+
+	var subscription config.GrantAlertSubscription
+	res := config.DB().Where(config.GrantAlertSubscription{UnsubscribeToken: token}).Limit(1).Find(&subscription)
+	if res.Error != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", res.Error)
+	} else if res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if err := config.DB().Delete(&subscription).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(subscription.UserID, "Unsubscribe GrantAlerts", "by link")
+	return c.String(http.StatusOK, "Вы отписались от уведомлений о новых грантах")
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Переход по ссылке из письма только показывает форму: сканеры ссылок не должны отписывать пользователя
+func TestConfirmUnsubscribeGrantAlerts(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/unsubscribeGrantAlertsByLink?token="+`a"b<c`, nil)
+	rec := httptest.NewRecorder()
+
+	if err := ConfirmUnsubscribeGrantAlerts(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, `method="post"`) {
+		t.Fatalf("ConfirmUnsubscribeGrantAlerts() = %d %q; want confirmation form", rec.Code, body)
+	}
+	if !strings.Contains(body, `action="?token=a%22b%3Cc"`) {
+		t.Errorf("token is not escaped in form action: %q", body)
+	}
+}
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"park/config"
+	"strconv"
+	"time"
+)
+
+const grantSchedulePollInterval = 5 * time.Minute
+
+// Смены статуса гранта модератором. Опубликованный, открытый и закрытый гранты дальше ведёт планировщик
+var grantStatusTransitions = map[string][]string{
+	config.GrantStatusDraft:     {config.GrantStatusPublished, config.GrantStatusOpen, config.GrantStatusArchived},
+	config.GrantStatusPublished: {config.GrantStatusDraft, config.GrantStatusOpen, config.GrantStatusClosed, config.GrantStatusArchived},
+	config.GrantStatusOpen:      {config.GrantStatusClosed, config.GrantStatusArchived},
+	config.GrantStatusClosed:    {config.GrantStatusPublished, config.GrantStatusOpen, config.GrantStatusArchived},
+	config.GrantStatusArchived:  {config.GrantStatusDraft},
+}
+
+// grantTermsOf — условия гранта; у гранта без условий они пустые, а статус — open
+func grantTermsOf(db *gorm.DB, grantId int) (config.GrantTerms, error) {
+	terms := config.GrantTerms{GrantID: grantId, Status: config.GrantStatusOpen}
+	err := db.Where(config.GrantTerms{GrantID: grantId}).Limit(1).Find(&terms).Error
+	return terms, err
+}
+
+// saveGrantTerms сохраняет условия и пересчитывает поисковый текст гранта: в него входят название и описание
+func saveGrantTerms(db *gorm.DB, terms *config.GrantTerms) error {
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "grant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"title", "description", "amount_min", "amount_max", "opens_at", "deadline", "status", "updated_at"}),
+	}).Create(terms).Error
+	if err != nil {
+		return err
+	}
+	return syncGrantSearch(db, "grants.id = ?", terms.GrantID)
+}
+
+// openGrants оставляет в запросе к grants только гранты, которые принимают заявки
+func openGrants(query *gorm.DB) *gorm.DB {
+	return query.Where("id NOT IN (?)", config.DB().Model(&config.GrantTerms{}).
+		Select("grant_id").Where("status <> ?", config.GrantStatusOpen))
+}
+
+// sampleGrantNotOpen возвращает 423, если грант шаблона не принимает заявки: продвигать заявку нельзя,
+// пока грант не откроется снова. Уже заполненные документы остаются доступны для скачивания
+func sampleGrantNotOpen(db *gorm.DB, sampleId int) error {
+	var sample config.Sample
+	if err := db.First(&sample, sampleId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	terms, err := grantTermsOf(db, sample.GrantID)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	if terms.Status != config.GrantStatusOpen {
+		return NewAPIError(http.StatusLocked, "grant_not_open").WithDetails(map[string]interface{}{
+			"status":   terms.Status,
+			"opensAt":  terms.OpensAt,
+			"deadline": terms.Deadline,
+		})
+	}
+	return nil
+}
+
+// StartGrantScheduler периодически открывает и закрывает опубликованные гранты по датам окна приёма заявок
+func StartGrantScheduler() {
+	go func() {
+		for {
+			applyGrantSchedule(time.Now())
+			time.Sleep(grantSchedulePollInterval)
+		}
+	}()
+}
+
+func applyGrantSchedule(now time.Time) {
+	db := config.DB()
+
+	var terms []config.GrantTerms
+	err := db.Where("status IN ?", []string{config.GrantStatusPublished, config.GrantStatusOpen, config.GrantStatusClosed}).
+		Find(&terms).Error
+	if err != nil {
+		fmt.Println("Ошибка загрузки сроков грантов:", err)
+		return
+	}
+
+	for _, t := range terms {
+		to := t.ScheduledStatus(now)
+		if to == t.Status {
+			continue
+		}
+
+		// Условие по старому статусу — чтобы не перезаписать смену статуса модератором или другим экземпляром
+		res := db.Model(&config.GrantTerms{}).
+			Where("id = ? AND status = ?", t.ID, t.Status).
+			Update("status", to)
+		if res.Error != nil {
+			fmt.Println("Ошибка смены статуса гранта:", res.Error)
+			continue
+		}
+		if res.RowsAffected > 0 {
+			AddLog(0, "Grant status", fmt.Sprintf("%d: %s -> %s", t.GrantID, t.Status, to))
+		}
+	}
+}
+
+func validateGrantTerms(terms config.GrantTerms) error {
+	validation := &ValidationError{}
+
+	if terms.AmountMin != nil && *terms.AmountMin < 0 {
+		validation.Add("amountMin", "сумма не может быть отрицательной")
+	}
+	if terms.AmountMax != nil && *terms.AmountMax < 0 {
+		validation.Add("amountMax", "сумма не может быть отрицательной")
+	}
+	if terms.AmountMin != nil && terms.AmountMax != nil && *terms.AmountMin > *terms.AmountMax {
+		validation.Add("amountMax", "меньше минимальной суммы")
+	}
+	if terms.OpensAt != nil && terms.Deadline != nil && terms.OpensAt.After(*terms.Deadline) {
+		validation.Add("deadline", "раньше начала приёма заявок")
+	}
+
+	return validation.Err()
+}
+
+// SetGrantTerms задаёт условия гранта для каталога целиком: незаполненные поля очищаются. Статус меняет
+// ChangeGrantStatus; у опубликованного гранта он пересчитывается по новым датам — продление срока открывает грант снова
+func SetGrantTerms(c echo.Context) error {
+	db := config.DB()
+
+	grantId, err := strconv.Atoi(c.Request().Header.Get("grantId"))
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "grantId"})
+	}
+
+	user := contextUser(c)
+	if !userCan(user, config.PermGrantWrite) {
+		return permissionDenied(user)
+	}
+
+	var grant config.Grant
+	if err := db.First(&grant, grantId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if !canAccessDecree(user, grant.DecreeID) {
+		return regionForbidden(c)
+	}
+
+	var terms config.GrantTerms
+	if err := bindEntity(c, "terms", "grantTerms", &terms); err != nil {
+		return err
+	}
+	if err := validateGrantTerms(terms); err != nil {
+		return err
+	}
+
+	existing, err := grantTermsOf(db, grant.ID)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	terms.ID = 0
+	terms.GrantID = grant.ID
+	terms.Status = existing.Status
+	if config.GrantScheduled(terms.Status) {
+		terms.Status = terms.ScheduledStatus(time.Now())
+	}
+
+	if err := saveGrantTerms(db, &terms); err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(user.ID, "Set GrantTerms", strconv.Itoa(grant.ID))
+
+	return c.JSON(http.StatusOK, terms)
+}
+
+// ChangeGrantStatus переводит грант в черновик, публикует, открывает или закрывает досрочно, отправляет в архив.
+// Опубликованный грант откроется в OpensAt; открытие сдвигает OpensAt на сейчас, закрытие — Deadline и будущий OpensAt
+func ChangeGrantStatus(c echo.Context) error {
+	db := config.DB()
+
+	grantId, err := strconv.Atoi(c.Request().Header.Get("grantId"))
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "grantId"})
+	}
+	status := c.Request().Header.Get("status")
+
+	user := contextUser(c)
+	if !userCan(user, config.PermGrantWrite) {
+		return permissionDenied(user)
+	}
+
+	if !config.IsGrantStatus(status) {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "status"})
+	}
+
+	var grant config.Grant
+	if err := db.First(&grant, grantId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if !canAccessDecree(user, grant.DecreeID) {
+		return regionForbidden(c)
+	}
+
+	terms, err := grantTermsOf(db, grant.ID)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	from := terms.Status
+	if !containsString(grantStatusTransitions[from], status) {
+		return NewAPIError(http.StatusConflict, "grant_status_transition").WithDetails(map[string]interface{}{
+			"from":     from,
+			"to":       status,
+			"expected": grantStatusTransitions[from],
+		})
+	}
+
+	now := time.Now()
+	switch status {
+	case config.GrantStatusOpen:
+		if terms.OpensAt != nil && terms.OpensAt.After(now) {
+			terms.OpensAt = &now
+		}
+		fallthrough
+	case config.GrantStatusPublished:
+		terms.Status = terms.ScheduledStatus(now)
+		if terms.Status == config.GrantStatusClosed {
+			return NewAPIError(http.StatusConflict, "grant_deadline_passed").WithDetails(map[string]interface{}{"deadline": terms.Deadline})
+		}
+	case config.GrantStatusClosed:
+		if terms.Deadline == nil || terms.Deadline.After(now) {
+			terms.Deadline = &now
+		}
+		// Иначе планировщик вернёт не открывшийся грант в published по будущему OpensAt
+		if terms.OpensAt != nil && terms.OpensAt.After(now) {
+			terms.OpensAt = &now
+		}
+		terms.Status = status
+	default:
+		terms.Status = status
+	}
+
+	if err := saveGrantTerms(db, &terms); err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(user.ID, "Change Grant status", fmt.Sprintf("%d: %s -> %s", grant.ID, from, terms.Status))
+
+	if !grantVisible(from) && grantVisible(terms.Status) {
+		enqueueGrantAlerts(grant.ID)
+	}
+
+	return c.JSON(http.StatusOK, terms)
+}
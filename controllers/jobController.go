@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"park/config"
+	"strconv"
+)
+
+const (
+	jobStepFindRequiredFields = "findRequiredFields"
+	jobStepOCR                = "ocr"
+	jobStepGPT                = "gpt"
+	jobStepSaveFilledFields   = "saveFilledFields"
+	jobStepDone               = "done"
+)
+
+// reportJobStep записывает текущий шаг задачи; jobId == 0 — задача без отслеживания
+func reportJobStep(jobId int, step string, current int, total int, detail string) {
+	if jobId == 0 {
+		return
+	}
+
+	config.DB().Model(&config.Job{}).Where("id = ?", jobId).Updates(map[string]interface{}{
+		"step":         step,
+		"step_current": current,
+		"step_total":   total,
+		"step_detail":  detail,
+	})
+}
+
+func GetAIJobStatus(c echo.Context) error {
+	db := config.DB()
+
+	sampleId := c.Request().Header.Get("sampleId")
+
+	user := contextUser(c)
+
+	sampleIdInt, err := strconv.Atoi(sampleId)
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "sampleId"})
+	}
+
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+	userSample, err := findUserSample(db, user.ID, company.ID, sampleIdInt)
+	if err != nil {
+		return NewAPIError(http.StatusNotFound, "user_sample_not_found")
+	}
+
+	// Задачи, поставленные до появления userSampleId, относятся к любой компании пользователя
+	var job config.Job
+	res := db.Where(config.Job{Type: jobTypeFindFieldsAI, UserID: user.ID, SampleID: sampleIdInt}).
+		Where("payload->>'userSampleId' = ? OR payload->>'userSampleId' IS NULL", strconv.Itoa(userSample.ID)).
+		Order("id desc").Limit(1).Find(&job)
+	if res.Error != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", res.Error)
+	} else if res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "job_not_found")
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
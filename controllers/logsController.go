@@ -2,7 +2,10 @@ package controllers
 
 import (
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"net/http"
 	"park/config"
+	"strconv"
 	"time"
 )
 
@@ -18,3 +21,25 @@ func AddLog(userId int, action string, description string) {
 
 	db.Create(&log)
 }
+
+func ListLogs(c echo.Context) error {
+	db := config.DB()
+
+	userId := c.Request().Header.Get("userId")
+
+	query := db.Order("id desc").Limit(500)
+	if userId != "" {
+		userIdInt, err := strconv.Atoi(userId)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "userId"})
+		}
+		query = query.Where(config.Log{UserID: userIdInt})
+	}
+
+	var logs []config.Log
+	if err := query.Find(&logs).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, logs)
+}
@@ -0,0 +1,395 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"park/config"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Веса совпадений при подборе постановлений
+const (
+	matchScoreCity           = 30
+	matchScoreRegion         = 20
+	matchScoreMainOkved      = 50
+	matchScoreMainOkvedGroup = 40
+	matchScoreOkved          = 30
+	matchScoreOkvedGroup     = 25
+	matchScoreExtraOkved     = 5
+	matchScoreExtraOkvedMax  = 20
+)
+
+// companyProfile — данные карточки компании, по которым она сопоставляется с постановлениями.
+// Region и City нормализованы normalizePlaceName
+type companyProfile struct {
+	MainOkved  string
+	Okveds     []string
+	Region     string
+	City       string
+	RegionName string
+	CityName   string
+}
+
+// decreeMatch — подходящее постановление, его оценка и причины совпадения
+type decreeMatch struct {
+	Decree  config.Decree
+	Score   int
+	Reasons []string
+}
+
+// grantMatch — причины, по которым грант попал в подбор; оценка — оценка его постановления
+type grantMatch struct {
+	GrantID  int      `json:"grantId"`
+	DecreeID int      `json:"decreeId"`
+	Score    int      `json:"score"`
+	Reasons  []string `json:"reasons"`
+}
+
+func companyProfileOf(card []byte) companyProfile {
+	value := func(path string) string {
+		for _, v := range ruleValues(card, path) {
+			if s := strings.TrimSpace(ruleValueString(v)); s != "" && s != "null" {
+				return s
+			}
+		}
+		return ""
+	}
+
+	profile := companyProfile{
+		MainOkved:  value("$.body.docs.0.КодОКВЭД"),
+		RegionName: value("$.body.docs.0.НаимРегион"),
+		CityName:   value("$.body.docs.0.НаимГород"),
+	}
+	// Компании вне городов привязаны к району
+	if profile.CityName == "" {
+		profile.CityName = value("$.body.docs.0.НаимРайон")
+	}
+	profile.Region = normalizePlaceName(profile.RegionName)
+	profile.City = normalizePlaceName(profile.CityName)
+
+	for _, v := range ruleValues(card, "$.body.docs.0.СвОКВЭДДоп[*].КодОКВЭД") {
+		if code := strings.TrimSpace(ruleValueString(v)); code != "" && code != profile.MainOkved {
+			profile.Okveds = append(profile.Okveds, code)
+		}
+	}
+	return profile
+}
+
+// Слова, которые пишут или опускают в названиях регионов и муниципалитетов по-разному
+var placeNameNoise = map[string]bool{
+	"республика": true, "респ": true, "область": true, "обл": true, "край": true,
+	"автономный": true, "автономная": true, "округ": true, "ао": true,
+	"город": true, "г": true, "федерального": true, "значения": true,
+	"район": true, "р-н": true, "муниципальный": true, "городской": true, "го": true, "мо": true,
+	"поселок": true, "пос": true, "пгт": true, "село": true, "с": true, "деревня": true, "д": true,
+}
+
+// normalizePlaceName приводит название региона или муниципалитета к виду для сравнения:
+// «г. Москва» и «Москва», «Респ. Татарстан» и «Республика Татарстан», «Свердловская обл» и «Свердловская область»
+func normalizePlaceName(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(name), "ё", "е")
+
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var kept []string
+	for _, word := range words {
+		word = strings.Trim(word, "-")
+		if word == "" || placeNameNoise[word] {
+			continue
+		}
+		kept = append(kept, word)
+	}
+	return strings.Join(kept, " ")
+}
+
+// decreeOkvedCodes разбирает Decree.OkvedList: массив кодов, массив объектов с кодом или строку с кодами через запятую
+func decreeOkvedCodes(raw json.RawMessage) []string {
+	var codes []string
+
+	var items []interface{}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		var list string
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil
+		}
+		items = []interface{}{list}
+	}
+
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			for _, code := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' || unicode.IsSpace(r) }) {
+				codes = append(codes, code)
+			}
+		case map[string]interface{}:
+			for _, key := range []string{"code", "Code", "КодОКВЭД"} {
+				if code, ok := v[key].(string); ok && code != "" {
+					codes = append(codes, strings.TrimSpace(code))
+					break
+				}
+			}
+		}
+	}
+	return codes
+}
+
+func okvedDigits(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, code)
+}
+
+// okvedWithin — код code совпадает с parent или входит в него по иерархии ОКВЭД (класс 62, подкласс 62.0,
+// группа 62.01, подгруппа 62.01.1): класс совпадает целиком, дальше каждая цифра — ступень ниже.
+// Поэтому «62.0» захватывает «62.01», но не «162.01»
+func okvedWithin(code string, parent string) bool {
+	codeClass, codeRest, _ := strings.Cut(strings.TrimSpace(code), ".")
+	parentClass, parentRest, _ := strings.Cut(strings.TrimSpace(parent), ".")
+	if parentClass == "" || codeClass != parentClass {
+		return false
+	}
+	return strings.HasPrefix(okvedDigits(codeRest), okvedDigits(parentRest))
+}
+
+// okvedKey приводит код ОКВЭД к виду «класс.цифры»: в нём okvedWithin — совпадение начала строки
+func okvedKey(code string) string {
+	class, rest, _ := strings.Cut(strings.TrimSpace(code), ".")
+	if class == "" {
+		return ""
+	}
+	if digits := okvedDigits(rest); digits != "" {
+		return class + "." + digits
+	}
+	return class
+}
+
+// okvedAncestors — okvedKey кода и всех уровней над ним: 62.01 → 62, 62.0, 62.01
+func okvedAncestors(code string) []string {
+	key := okvedKey(code)
+	if key == "" {
+		return nil
+	}
+	class, digits, _ := strings.Cut(key, ".")
+	ancestors := []string{class}
+	for i := 1; i <= len(digits); i++ {
+		ancestors = append(ancestors, class+"."+digits[:i])
+	}
+	return ancestors
+}
+
+// okvedMatch ищет среди кодов постановления тот, что совпадает с code; exact — совпадение точное, а не по группе
+func okvedMatch(code string, decreeCodes []string) (matched string, exact bool, ok bool) {
+	for _, decreeCode := range decreeCodes {
+		if okvedDigits(code) == okvedDigits(decreeCode) {
+			return decreeCode, true, true
+		}
+	}
+	for _, decreeCode := range decreeCodes {
+		if okvedWithin(code, decreeCode) {
+			return decreeCode, false, true
+		}
+	}
+	return "", false, false
+}
+
+// matchDecree оценивает постановление для компании. Постановление подходит, если совпадает место —
+// муниципалитет или, для региональных постановлений, регион, — и, при checkOkved, хотя бы один ОКВЭД
+func matchDecree(profile companyProfile, decree config.Decree, checkOkved bool) (decreeMatch, bool) {
+	match := decreeMatch{Decree: decree}
+
+	if city := normalizePlaceName(decree.City); city != "" {
+		if city != profile.City {
+			return match, false
+		}
+		match.Score += matchScoreCity
+		match.Reasons = append(match.Reasons, "муниципалитет: "+profile.CityName)
+	} else {
+		if region := normalizePlaceName(decree.Region); region == "" || region != profile.Region {
+			return match, false
+		}
+		match.Score += matchScoreRegion
+		match.Reasons = append(match.Reasons, "регион: "+profile.RegionName)
+	}
+
+	if !checkOkved {
+		return match, true
+	}
+
+	decreeCodes := decreeOkvedCodes(decree.OkvedList)
+	okvedScore := 0
+	if profile.MainOkved != "" {
+		if decreeCode, exact, ok := okvedMatch(profile.MainOkved, decreeCodes); ok {
+			if exact {
+				okvedScore = matchScoreMainOkved
+				match.Reasons = append(match.Reasons, "основной ОКВЭД "+profile.MainOkved+" указан в постановлении")
+			} else {
+				okvedScore = matchScoreMainOkvedGroup
+				match.Reasons = append(match.Reasons, "основной ОКВЭД "+profile.MainOkved+" входит в группу "+decreeCode)
+			}
+		}
+	}
+
+	// Главный вклад даёт лучший код, остальные совпадения немного поднимают оценку
+	best, matched := 0, 0
+	for _, code := range profile.Okveds {
+		decreeCode, exact, ok := okvedMatch(code, decreeCodes)
+		if !ok {
+			continue
+		}
+
+		score := matchScoreOkvedGroup
+		reason := "дополнительный ОКВЭД " + code + " входит в группу " + decreeCode
+		if exact {
+			score = matchScoreOkved
+			reason = "дополнительный ОКВЭД " + code + " указан в постановлении"
+		}
+		match.Reasons = append(match.Reasons, reason)
+
+		matched++
+		if score > best {
+			best = score
+		}
+	}
+	if okvedScore == 0 && matched > 0 {
+		okvedScore = best
+		matched--
+	}
+	extra := matched * matchScoreExtraOkved
+	if extra > matchScoreExtraOkvedMax {
+		extra = matchScoreExtraOkvedMax
+	}
+
+	if okvedScore == 0 {
+		return match, false
+	}
+	match.Score += okvedScore + extra
+	return match, true
+}
+
+// matchDecrees возвращает подходящие постановления от более к менее подходящим; при равной оценке — новые первыми
+func matchDecrees(profile companyProfile, decrees []config.Decree, checkOkved bool) []decreeMatch {
+	var matches []decreeMatch
+	for _, decree := range decrees {
+		if match, ok := matchDecree(profile, decree, checkOkved); ok {
+			matches = append(matches, match)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Decree.ID > matches[j].Decree.ID
+	})
+	return matches
+}
+
+// regionalDecrees — постановления региона компании, включая муниципальные; город компании не учитывается
+func regionalDecrees(db *gorm.DB, company config.Company) ([]config.Decree, error) {
+	decrees := []config.Decree{}
+	region := companyProfileOf(company.CardData).Region
+	if region == "" {
+		return decrees, nil
+	}
+
+	decreeIds := db.Model(&config.DecreeIndex{}).Select("decree_id").Where("region = ?", region)
+	err := db.Where("id IN (?)", decreeIds).Order("id desc").Find(&decrees).Error
+	return decrees, err
+}
+
+// placeDecreeIds — подзапрос постановлений, место которых совпадает с местом компании по правилам matchDecree:
+// муниципальные — по муниципалитету, региональные — по региону. Остальные условия проверяет matchDecree
+func placeDecreeIds(db *gorm.DB, profile companyProfile) *gorm.DB {
+	return db.Model(&config.DecreeIndex{}).Select("decree_id").
+		Where("(city <> '' AND city = ?) OR (city = '' AND region <> '' AND region = ?)", profile.City, profile.Region)
+}
+
+// decreeIndexVersion увеличивается при изменении normalizePlaceName или состава DecreeIndex
+const decreeIndexVersion = 2
+
+// syncDecreeIndex пересчитывает DecreeIndex постановления; вызывается при создании и изменении Decree
+func syncDecreeIndex(db *gorm.DB, decree config.Decree) error {
+	codes := []string{}
+	prefixes := []string{}
+	seen := make(map[string]bool)
+	for _, code := range decreeOkvedCodes(decree.OkvedList) {
+		key := okvedKey(code)
+		if key == "" || containsString(codes, key) {
+			continue
+		}
+		codes = append(codes, key)
+		for _, ancestor := range okvedAncestors(key) {
+			if !seen[ancestor] {
+				seen[ancestor] = true
+				prefixes = append(prefixes, ancestor)
+			}
+		}
+	}
+	codesJSON, _ := json.Marshal(codes)
+	prefixesJSON, _ := json.Marshal(prefixes)
+
+	index := config.DecreeIndex{
+		DecreeID:      decree.ID,
+		Region:        normalizePlaceName(decree.Region),
+		City:          normalizePlaceName(decree.City),
+		OkvedCodes:    codesJSON,
+		OkvedPrefixes: prefixesJSON,
+		Version:       decreeIndexVersion,
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "decree_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"region", "city", "okved_codes", "okved_prefixes", "version"}),
+	}).Create(&index).Error
+}
+
+// InitDecreeIndex строит DecreeIndex для постановлений, у которых его нет или он построен старой версией
+func InitDecreeIndex() {
+	db := config.DB()
+
+	current := db.Model(&config.DecreeIndex{}).Select("decree_id").Where("version = ?", decreeIndexVersion)
+	var decrees []config.Decree
+	err := db.Where("id NOT IN (?)", current).FindInBatches(&decrees, 500, func(tx *gorm.DB, batch int) error {
+		for _, decree := range decrees {
+			if err := syncDecreeIndex(db, decree); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		fmt.Println("Ошибка построения индекса постановлений:", err)
+	}
+}
+
+// rankGrants упорядочивает гранты по оценке их постановлений и объясняет, почему каждый подошёл
+func rankGrants(matches []decreeMatch, grants []config.Grant) ([]config.Grant, []grantMatch) {
+	position := make(map[int]int, len(matches))
+	for i, match := range matches {
+		position[match.Decree.ID] = i
+	}
+
+	sort.SliceStable(grants, func(i, j int) bool {
+		return position[grants[i].DecreeID] < position[grants[j].DecreeID]
+	})
+
+	grantMatches := make([]grantMatch, 0, len(grants))
+	for _, grant := range grants {
+		match := matches[position[grant.DecreeID]]
+		grantMatches = append(grantMatches, grantMatch{
+			GrantID:  grant.ID,
+			DecreeID: grant.DecreeID,
+			Score:    match.Score,
+			Reasons:  match.Reasons,
+		})
+	}
+	return grants, grantMatches
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOkvedWithin(t *testing.T) {
+	tests := []struct {
+		code   string
+		parent string
+		want   bool
+	}{
+		{"62.01", "62.0", true},
+		{"162.01", "62.0", false},
+		{"62.01", "62", true},
+		{"62.01.1", "62.01", true},
+		{"62.01", "62.01", true},
+		{"62", "62.01", false},
+		{"62.02", "62.01", false},
+		{"63.11", "62", false},
+		{" 62.01 ", "62.0", true},
+		{"62.01", "", false},
+	}
+	for _, tt := range tests {
+		if got := okvedWithin(tt.code, tt.parent); got != tt.want {
+			t.Errorf("okvedWithin(%q, %q) = %v; want %v", tt.code, tt.parent, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePlaceName(t *testing.T) {
+	tests := []struct {
+		a string
+		b string
+	}{
+		{"г. Москва", "Москва"},
+		{"Респ. Татарстан", "Республика Татарстан"},
+		{"Свердловская обл", "Свердловская область"},
+		{"  САНКТ-ПЕТЕРБУРГ ", "город федерального значения Санкт-Петербург"},
+		{"Ханты-Мансийский автономный округ - Югра", "Ханты-Мансийский АО — Югра"},
+		{"пгт. Новосёлово", "поселок Новоселово"},
+	}
+	for _, tt := range tests {
+		if a, b := normalizePlaceName(tt.a), normalizePlaceName(tt.b); a != b || a == "" {
+			t.Errorf("normalizePlaceName(%q) = %q, normalizePlaceName(%q) = %q; want equal", tt.a, a, tt.b, b)
+		}
+	}
+
+	if a, b := normalizePlaceName("Московская область"), normalizePlaceName("Москва"); a == b {
+		t.Errorf("normalizePlaceName: %q and %q must differ", a, b)
+	}
+}
+
+func TestOkvedAncestors(t *testing.T) {
+	tests := []struct {
+		code string
+		want []string
+	}{
+		{"62", []string{"62"}},
+		{"62.01", []string{"62", "62.0", "62.01"}},
+		{"62.01.1", []string{"62", "62.0", "62.01", "62.011"}},
+		{" 62.0 ", []string{"62", "62.0"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := okvedAncestors(tt.code)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("okvedAncestors(%q) = %v; want %v", tt.code, got, tt.want)
+		}
+		// Каждый уровень над кодом содержит его в смысле okvedWithin
+		for _, ancestor := range got {
+			if !okvedWithin(tt.code, ancestor) {
+				t.Errorf("okvedWithin(%q, %q) = false for ancestor", tt.code, ancestor)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"park/config"
+	"time"
+)
+
+func ListNotifications(c echo.Context) error {
+	query := config.DB().Where(config.Notification{UserID: contextUser(c).ID})
+	if c.Request().Header.Get("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []config.Notification
+	if err := query.Order("id desc").Limit(200).Find(&notifications).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+// MarkNotificationsRead отмечает прочитанным уведомление notificationId, без него — все уведомления пользователя
+func MarkNotificationsRead(c echo.Context) error {
+	query := config.DB().Model(&config.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", contextUser(c).ID)
+	if notificationId := c.Request().Header.Get("notificationId"); notificationId != "" {
+		query = query.Where("id = ?", notificationId)
+	}
+
+	if err := query.Update("read_at", time.Now()).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
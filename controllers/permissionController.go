@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"park/config"
+	"sync"
+	"time"
+)
+
+// Права ролей кешируются ненадолго: изменения с другого экземпляра сервиса применятся не позже чем через TTL
+const rolePermissionsCacheTTL = time.Minute
+
+var rolePermissionsCache struct {
+	sync.RWMutex
+	permissions map[string]map[string]bool
+	loadedAt    time.Time
+}
+
+func loadRolePermissions() map[string]map[string]bool {
+	rolePermissionsCache.RLock()
+	permissions := rolePermissionsCache.permissions
+	fresh := time.Since(rolePermissionsCache.loadedAt) < rolePermissionsCacheTTL
+	rolePermissionsCache.RUnlock()
+	if permissions != nil && fresh {
+		return permissions
+	}
+
+	var rows []config.RolePermission
+	if err := config.DB().Find(&rows).Error; err != nil {
+		// Лучше отработать на устаревших правах, чем отказать всем
+		if permissions != nil {
+			return permissions
+		}
+		return map[string]map[string]bool{}
+	}
+
+	permissions = make(map[string]map[string]bool)
+	for _, row := range rows {
+		if permissions[row.Role] == nil {
+			permissions[row.Role] = make(map[string]bool)
+		}
+		permissions[row.Role][row.Permission] = true
+	}
+
+	rolePermissionsCache.Lock()
+	rolePermissionsCache.permissions = permissions
+	rolePermissionsCache.loadedAt = time.Now()
+	rolePermissionsCache.Unlock()
+
+	return permissions
+}
+
+func invalidateRolePermissions() {
+	rolePermissionsCache.Lock()
+	rolePermissionsCache.permissions = nil
+	rolePermissionsCache.Unlock()
+}
+
+// userCan проверяет, есть ли у роли пользователя право permission
+func userCan(user config.User, permission string) bool {
+	if user.ID == 0 {
+		return false
+	}
+	return loadRolePermissions()[user.Role][permission]
+}
+
+// permissionDenied — отказ в доступе: анониму 401 unauthorized, чтобы он вошёл заново,
+// пользователю без права — 403 access_denied
+func permissionDenied(user config.User) error {
+	if user.ID == 0 {
+		return NewAPIError(http.StatusUnauthorized, "unauthorized")
+	}
+	return NewAPIError(http.StatusForbidden, "access_denied")
+}
+
+// RequirePermission пропускает только пользователей, чья роль имеет право permission
+func RequirePermission(permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !userCan(contextUser(c), permission) {
+				return permissionDenied(contextUser(c))
+			}
+			return next(c)
+		}
+	}
+}
+
+func ListRolePermissions(c echo.Context) error {
+	db := config.DB()
+
+	var rows []config.RolePermission
+	if err := db.Order("role, permission").Find(&rows).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	roles := make(map[string][]string)
+	for _, row := range rows {
+		roles[row.Role] = append(roles[row.Role], row.Permission)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"roles":       roles,
+		"permissions": config.Permissions,
+	})
+}
+
+func GrantRolePermission(c echo.Context) error {
+	db := config.DB()
+
+	role := c.Request().Header.Get("role")
+	permission := c.Request().Header.Get("permission")
+
+	if role == "" || !config.IsPermission(permission) {
+		return NewAPIError(http.StatusBadRequest, "invalid_params")
+	}
+
+	row := config.RolePermission{Role: role, Permission: permission}
+	if err := db.Where(row).FirstOrCreate(&row).Error; err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+	invalidateRolePermissions()
+
+	AddLog(contextUser(c).ID, "Grant permission", role+": "+permission)
+
+	return c.JSON(http.StatusOK, row)
+}
+
+func RevokeRolePermission(c echo.Context) error {
+	db := config.DB()
+
+	role := c.Request().Header.Get("role")
+	permission := c.Request().Header.Get("permission")
+
+	if role == "" || !config.IsPermission(permission) {
+		return NewAPIError(http.StatusBadRequest, "invalid_params")
+	}
+
+	// Иначе можно лишить всех администраторов доступа к управлению правами
+	if role == "admin" && permission == config.PermPermissionsManage {
+		return NewAPIError(http.StatusForbidden, "protected_permission")
+	}
+
+	if err := db.Where(config.RolePermission{Role: role, Permission: permission}).Delete(&config.RolePermission{}).Error; err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+	invalidateRolePermissions()
+
+	AddLog(contextUser(c).ID, "Revoke permission", role+": "+permission)
+
+	return c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"park/config"
+	"strings"
+	"time"
+)
+
+// Маршруты заполнения, запускающие фоновую обработку заявки, и ресурсы тарифа, которые она расходует;
+// пути без префикса /api/v1. При вызове только проверяется, что ресурс не исчерпан: страницы OCR и запросы
+// к YandexGPT списываются по факту в фоновой задаче, заявка — один раз, когда обработка впервые завершится
+var quotaRoutes = map[string][]string{
+	"/findRequiredFieldsAI":      {config.UsageOCRPages, config.UsageAIRequests},
+	"/retryFindRequiredFieldsAI": {config.UsageOCRPages, config.UsageAIRequests},
+}
+
+// quotaUsage — расход ресурса компании за месяц; Limit и Remaining nil — без ограничения
+type quotaUsage struct {
+	Metric    string `json:"metric"`
+	Limit     *int   `json:"limit"`
+	Used      int    `json:"used"`
+	UsedByMe  int    `json:"usedByMe"`
+	Remaining *int   `json:"remaining"`
+}
+
+// companyPlan — действующий тариф компании и его назначение; без назначения или после его окончания — тариф по умолчанию
+func companyPlan(db *gorm.DB, companyId int, now time.Time) (config.Plan, *config.PlanAssignment, error) {
+	var plan config.Plan
+
+	var assignment config.PlanAssignment
+	res := db.Where(config.PlanAssignment{CompanyID: companyId}).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Limit(1).Find(&assignment)
+	if res.Error != nil {
+		return plan, nil, res.Error
+	}
+	if res.RowsAffected > 0 {
+		res = db.Limit(1).Find(&plan, assignment.PlanID)
+		if res.Error != nil || res.RowsAffected > 0 {
+			return plan, &assignment, res.Error
+		}
+	}
+
+	err := db.Where(config.Plan{Code: config.DefaultPlanCode()}).Limit(1).Find(&plan).Error
+	return plan, nil, err
+}
+
+// companyQuota — лимиты тарифа и расход компании за месяц, в котором находится now
+func companyQuota(db *gorm.DB, companyId int, userId int, plan config.Plan, now time.Time) ([]quotaUsage, error) {
+	var counters []config.UsageCounter
+	err := db.Where(config.UsageCounter{CompanyID: companyId, Period: config.UsagePeriod(now)}).Find(&counters).Error
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]int)
+	usedByMe := make(map[string]int)
+	for _, counter := range counters {
+		used[counter.Metric] += counter.Count
+		if counter.UserID == userId {
+			usedByMe[counter.Metric] += counter.Count
+		}
+	}
+
+	usage := make([]quotaUsage, 0, len(config.UsageMetrics))
+	for _, metric := range config.UsageMetrics {
+		item := quotaUsage{Metric: metric, Limit: plan.Limit(metric), Used: used[metric], UsedByMe: usedByMe[metric]}
+		if item.Limit != nil {
+			remaining := *item.Limit - item.Used
+			if remaining < 0 {
+				remaining = 0
+			}
+			item.Remaining = &remaining
+		}
+		usage = append(usage, item)
+	}
+	return usage, nil
+}
+
+// recordUsage учитывает amount единиц ресурса metric, израсходованных компанией от имени пользователя
+func recordUsage(db *gorm.DB, companyId int, userId int, metric string, amount int) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	counter := config.UsageCounter{
+		CompanyID: companyId,
+		UserID:    userId,
+		Metric:    metric,
+		Period:    config.UsagePeriod(time.Now()),
+		Count:     amount,
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "company_id"}, {Name: "user_id"}, {Name: "metric"}, {Name: "period"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("usage_counters.count + ?", amount)}),
+	}).Create(&counter).Error
+}
+
+// recordUserSampleUsage учитывает расход фоновой задачи по заявке на компанию, за которой заявка закреплена
+func recordUserSampleUsage(db *gorm.DB, userSampleId int, userId int, metric string, amount int) {
+	var link config.UserSampleCompany
+	db.Where(config.UserSampleCompany{UserSampleID: userSampleId}).Limit(1).Find(&link)
+
+	if err := recordUsage(db, link.CompanyID, userId, metric, amount); err != nil {
+		fmt.Println("Ошибка учёта расхода тарифа:", err)
+	}
+}
+
+// chargeUserSampleApplication списывает с компании заявку, если за неё ещё не списывали
+func chargeUserSampleApplication(db *gorm.DB, userSampleId int, userId int) {
+	var link config.UserSampleCompany
+	db.Where(config.UserSampleCompany{UserSampleID: userSampleId}).Limit(1).Find(&link)
+
+	charge := config.UserSampleCharge{UserSampleID: userSampleId, CompanyID: link.CompanyID, UserID: userId}
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&charge)
+	if res.Error == nil && res.RowsAffected > 0 {
+		res.Error = recordUsage(db, link.CompanyID, userId, config.UsageApplications, 1)
+	}
+	if res.Error != nil {
+		fmt.Println("Ошибка учёта расхода тарифа:", res.Error)
+	}
+}
+
+func quotaExceeded(plan config.Plan, item quotaUsage, now time.Time) error {
+	return NewAPIError(http.StatusPaymentRequired, "quota_exceeded").WithDetails(map[string]interface{}{
+		"plan":   plan.Code,
+		"metric": item.Metric,
+		"limit":  item.Limit,
+		"used":   item.Used,
+		"period": config.UsagePeriod(now),
+	})
+}
+
+// checkApplicationQuota не даёт начать обработку новой заявки, если заявки тарифа исчерпаны;
+// заявку, за которую уже списали, можно обрабатывать повторно
+func checkApplicationQuota(db *gorm.DB, companyId int, user config.User, userSampleId int) error {
+	if userCan(user, config.PermQuotaUnlimited) {
+		return nil
+	}
+
+	var charged int64
+	if err := db.Model(&config.UserSampleCharge{}).Where(config.UserSampleCharge{UserSampleID: userSampleId}).Count(&charged).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	} else if charged > 0 {
+		return nil
+	}
+
+	now := time.Now()
+	plan, _, err := companyPlan(db, companyId, now)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	usage, err := companyQuota(db, companyId, user.ID, plan, now)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	for _, item := range usage {
+		if item.Metric == config.UsageApplications && item.Remaining != nil && *item.Remaining < 1 {
+			return quotaExceeded(plan, item, now)
+		}
+	}
+	return nil
+}
+
+// checkUserSampleQuota проверяет в фоновой задаче, что у компании заявки осталось amount единиц ресурса metric;
+// при нехватке возвращает *JobFailure, чтобы задача завершилась без повторов
+func checkUserSampleQuota(db *gorm.DB, userSampleId int, user config.User, metric string, amount int) error {
+	if userCan(user, config.PermQuotaUnlimited) {
+		return nil
+	}
+
+	var link config.UserSampleCompany
+	if err := db.Where(config.UserSampleCompany{UserSampleID: userSampleId}).Limit(1).Find(&link).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	plan, _, err := companyPlan(db, link.CompanyID, now)
+	if err != nil {
+		return err
+	}
+	usage, err := companyQuota(db, link.CompanyID, user.ID, plan, now)
+	if err != nil {
+		return err
+	}
+	for _, item := range usage {
+		if item.Metric == metric && item.Remaining != nil && *item.Remaining < amount {
+			return &JobFailure{Code: "quota_exceeded", Reason: fmt.Sprintf("Исчерпан лимит тарифа %s: %s (осталось %d, нужно %d)", plan.Code, metric, *item.Remaining, amount)}
+		}
+	}
+	return nil
+}
+
+// QuotaMiddleware не пускает на маршруты заполнения из quotaRoutes, если ресурс тарифа активной компании
+// исчерпан. Без пользователя или компании решает обработчик
+func QuotaMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		metrics, ok := quotaRoutes[strings.TrimPrefix(c.Path(), apiV1Prefix)]
+		user := contextUser(c)
+		if !ok || user.ID == 0 || userCan(user, config.PermQuotaUnlimited) {
+			return next(c)
+		}
+
+		company, err := activeCompany(c)
+		if err != nil {
+			return next(c)
+		}
+
+		db := config.DB()
+		now := time.Now()
+		plan, _, err := companyPlan(db, company.ID, now)
+		if err != nil {
+			return wrapError(http.StatusInternalServerError, "database_error", err)
+		}
+		usage, err := companyQuota(db, company.ID, user.ID, plan, now)
+		if err != nil {
+			return wrapError(http.StatusInternalServerError, "database_error", err)
+		}
+
+		for _, metric := range metrics {
+			for _, item := range usage {
+				if item.Metric == metric && item.Remaining != nil && *item.Remaining < 1 {
+					return quotaExceeded(plan, item, now)
+				}
+			}
+		}
+
+		return next(c)
+	}
+}
+
+// GetQuota — тариф активной компании и остаток ресурсов на текущий месяц
+func GetQuota(c echo.Context) error {
+	db := config.DB()
+	user := contextUser(c)
+
+	company, err := activeCompany(c)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	plan, assignment, err := companyPlan(db, company.ID, now)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	usage, err := companyQuota(db, company.ID, user.ID, plan, now)
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	var expiresAt *time.Time
+	if assignment != nil {
+		expiresAt = assignment.ExpiresAt
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"plan":      plan,
+		"expiresAt": expiresAt,
+		"period":    config.UsagePeriod(now),
+		"unlimited": userCan(user, config.PermQuotaUnlimited),
+		"usage":     usage,
+	})
+}
+
+func ListPlans(c echo.Context) error {
+	var plans []config.Plan
+	if err := config.DB().Order("id").Find(&plans).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, plans)
+}
+
+func validatePlan(plan config.Plan) error {
+	validation := &ValidationError{}
+
+	if strings.TrimSpace(plan.Code) == "" {
+		validation.Add("code", "обязательное поле")
+	}
+	for _, metric := range config.UsageMetrics {
+		if limit := plan.Limit(metric); limit != nil && *limit < 0 {
+			validation.Add(metric, "лимит не может быть отрицательным")
+		}
+	}
+
+	return validation.Err()
+}
+
+// SavePlan создаёт тариф или меняет тариф с тем же кодом; пустой лимит снимает ограничение
+func SavePlan(c echo.Context) error {
+	db := config.DB()
+	user := contextUser(c)
+
+	var plan config.Plan
+	if err := bindEntity(c, "plan", "plan", &plan); err != nil {
+		return err
+	}
+	plan.Code = strings.TrimSpace(plan.Code)
+	if err := validatePlan(plan); err != nil {
+		return err
+	}
+
+	plan.ID = 0
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "applications", "ocr_pages", "ai_requests"}),
+	}).Create(&plan).Error
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(user.ID, "Save Plan", plan.Code)
+	return c.JSON(http.StatusOK, plan)
+}
+
+// AssignPlan назначает компании тариф planCode до expiresAt (без него — бессрочно)
+func AssignPlan(c echo.Context) error {
+	db := config.DB()
+	user := contextUser(c)
+
+	companyINN := c.Request().Header.Get("companyINN")
+	planCode := c.Request().Header.Get("planCode")
+	if companyINN == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "companyINN"})
+	} else if planCode == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "planCode"})
+	}
+
+	var company config.Company
+	res := db.Where(config.Company{INN: companyINN}).Limit(1).Find(&company)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "company_not_found")
+	}
+
+	var plan config.Plan
+	res = db.Where(config.Plan{Code: planCode}).Limit(1).Find(&plan)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "not_found").WithDetails(map[string]string{"field": "planCode"})
+	}
+
+	assignment := config.PlanAssignment{CompanyID: company.ID, PlanID: plan.ID, AssignedBy: user.ID}
+	if value := c.Request().Header.Get("expiresAt"); value != "" {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "expiresAt"})
+		}
+		assignment.ExpiresAt = &expiresAt
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "company_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"plan_id", "expires_at", "assigned_by", "updated_at"}),
+	}).Create(&assignment).Error
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(user.ID, "Assign Plan", companyINN+": "+planCode)
+	return c.JSON(http.StatusOK, assignment)
+}
@@ -1,103 +1,583 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"park/config"
+	"path"
+	"strconv"
+	"sync"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var ZCBQueue = NewJobQueue(300*time.Millisecond, 5000)
-var AIQueue = NewJobQueue(300*time.Millisecond, 5000)
-var OCRQueue = NewJobQueue(1000*time.Millisecond, 10)
-var GPTQueue = NewJobQueue(1000*time.Millisecond, 10)
-var LibreQueue = NewJobQueue(300*time.Millisecond, 10)
+var ZCBQueue = NewJobQueue("zcb", 300*time.Millisecond, 3)
+var AIQueue = NewJobQueue("ai", 300*time.Millisecond, 3)
+var OCRQueue = NewJobQueue("ocr", 1000*time.Millisecond, 3)
+var GPTQueue = NewJobQueue("gpt", 1000*time.Millisecond, 3)
+var LibreQueue = NewJobQueue("libre", 300*time.Millisecond, 3)
+var MailQueue = NewJobQueue("mail", 1000*time.Millisecond, 3)
 
-func ZCBSendToQueue(f func() []byte) []byte {
-	ch := make(chan []byte)
-	ZCBQueue.Add(func() error {
-		ch <- f()
-		return nil
-	})
-	return <-ch
+const (
+	jobTypeZCBRequest     = "zcbRequest"
+	jobTypeFindFieldsAI   = "findRequiredFieldsAI"
+	jobTypeScanOcr        = "scanOcr"
+	jobTypeGPTRequest     = "gptRequest"
+	jobTypeConvertDocx    = "convertDocxToPDF"
+	jobLeaseDuration      = 10 * time.Minute
+	jobIdlePollInterval   = 5 * time.Second
+	jobWaitPollInterval   = 2 * time.Second
+	jobSyncWaitTimeout    = 15 * time.Minute
+	jobRetryBaseDelay     = 2 * time.Second
+	jobRetryMaxDelay      = 10 * time.Minute
+	jobDeadlineExpiredMsg = "истёк срок ожидания результата"
+	// jobPriorityBackground — плановые задачи уступают очередь задачам, результат которых ждёт пользователь
+	jobPriorityBackground = -1
+
+	jobFailureCompanyDataNotFound    = "company_data_not_found"
+	jobFailureCompanyDataUnavailable = "company_data_unavailable"
+)
+
+type zcbJobPayload struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+	// URL — задачи, поставленные до появления Endpoint
+	URL string `json:"url,omitempty"`
 }
 
-func AISendToQueueAsync(f func() []byte) {
-	AIQueue.Add(func() error {
-		f()
-		return nil
-	})
+type userSampleJobPayload struct {
+	UserID   int    `json:"userId"`
+	SampleID string `json:"sampleId"`
+	// UserSampleID — заявка конкретной компании; в задачах, поставленных раньше, его нет
+	UserSampleID int `json:"userSampleId,omitempty"`
 }
 
-func AISendToQueueSync(f func() []byte) []byte {
-	ch := make(chan []byte, 1)
-	AIQueue.Add(func() error {
-		ch <- f()
-		return nil
-	})
-	return <-ch
+type ocrJobPayload struct {
+	FileName    string `json:"fileName"`
+	UserID      int    `json:"userId"`
+	SampleID    string `json:"sampleId"`
+	ParentJobID int    `json:"parentJobId"`
+	// UserSampleID — заявка, на компанию которой списываются страницы; в задачах, поставленных раньше, его нет
+	UserSampleID int `json:"userSampleId,omitempty"`
 }
 
-func OCRSendToQueueSync(f func() []byte) []byte {
-	ch := make(chan []byte, 1)
-	OCRQueue.Add(func() error {
-		ch <- f()
-		return nil
-	})
-	return <-ch
+type gptJobPayload struct {
+	Chunk    string `json:"chunk"`
+	UserID   int    `json:"userId"`
+	SampleID string `json:"sampleId"`
+	// Dir — каталог заявки в хранилище; в задачах, поставленных раньше, его нет
+	Dir string `json:"dir,omitempty"`
+}
+
+type libreJobPayload struct {
+	Docx []byte `json:"docx"`
+}
+
+// ZCBSendToQueue запрашивает данные компании у CompanyDataProvider через очередь ZCB.
+// Ошибки — errCompanyDataNotFound или errCompanyDataUnavailable
+func ZCBSendToQueue(id string, endpoint string) ([]byte, error) {
+	result, err := ZCBQueue.EnqueueAndWait(config.Job{Type: jobTypeZCBRequest}, zcbJobPayload{ID: id, Endpoint: endpoint})
+	var failure *JobFailure
+	if errors.As(err, &failure) && failure.Code == jobFailureCompanyDataNotFound {
+		return nil, fmt.Errorf("%w: %s", errCompanyDataNotFound, failure.Reason)
+	} else if err != nil {
+		fmt.Println("Ошибка запроса к ЗЧБ:", err)
+		return nil, fmt.Errorf("%w: %v", errCompanyDataUnavailable, err)
+	}
+	return result, nil
+}
+
+func AISendToQueueAsync(userId int, userSample config.UserSample) (config.Job, error) {
+	return AIQueue.Enqueue(
+		config.Job{Type: jobTypeFindFieldsAI, UserID: userId, SampleID: userSample.SampleID},
+		userSampleJobPayload{UserID: userId, SampleID: strconv.Itoa(userSample.SampleID), UserSampleID: userSample.ID},
+	)
 }
 
-func GPTSendToQueueSync(f func() string) string {
-	ch := make(chan string, 1)
-	GPTQueue.Add(func() error {
-		ch <- f()
+// jobUserSample находит заявку задачи: по UserSampleID или, для старых задач, по пользователю и шаблону
+func jobUserSample(db *gorm.DB, userId int, payload userSampleJobPayload) (config.UserSample, error) {
+	var userSample config.UserSample
+	if payload.UserSampleID != 0 {
+		err := db.Where(config.UserSample{UserID: userId}).First(&userSample, payload.UserSampleID).Error
+		return userSample, err
+	}
+	sampleId, _ := strconv.Atoi(payload.SampleID)
+	err := db.Where(config.UserSample{UserID: userId, SampleID: sampleId}).First(&userSample).Error
+	return userSample, err
+}
+
+// OCRSendToQueueSync распознаёт PDF; прогресс по страницам пишется в задачу parentJobId
+func OCRSendToQueueSync(fileName string, userId int, sampleId string, userSampleId int, parentJobId int) []byte {
+	result, err := OCRQueue.EnqueueAndWait(
+		config.Job{Type: jobTypeScanOcr, UserID: userId},
+		ocrJobPayload{FileName: fileName, UserID: userId, SampleID: sampleId, ParentJobID: parentJobId, UserSampleID: userSampleId},
+	)
+	if err != nil {
+		fmt.Println("Ошибка OCR:", err)
 		return nil
-	})
-	return <-ch
+	}
+	return result
 }
 
-func LibreSendToQueueSync(f func() string) string {
-	ch := make(chan string, 1)
-	LibreQueue.Add(func() error {
-		ch <- f()
+func GPTSendToQueueSync(chunk string, userId int, sampleId string, dir string) string {
+	result, err := GPTQueue.EnqueueAndWait(config.Job{Type: jobTypeGPTRequest, UserID: userId}, gptJobPayload{Chunk: chunk, UserID: userId, SampleID: sampleId, Dir: dir})
+	if err != nil {
+		fmt.Println("Ошибка запроса к YandexGPT:", err)
+		return ""
+	}
+	return string(result)
+}
+
+func LibreSendToQueueSync(docx []byte) []byte {
+	result, err := LibreQueue.EnqueueAndWait(config.Job{Type: jobTypeConvertDocx}, libreJobPayload{Docx: docx})
+	if err != nil {
+		fmt.Println("Ошибка конвертации LibreOffice:", err)
 		return nil
-	})
-	return <-ch
+	}
+	return result
 }
 
 // --------------
 func init() {
+	RegisterJobHandler(jobTypeZCBRequest, func(job config.Job) ([]byte, error) {
+		var payload zcbJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, err
+		}
+		endpoint := payload.Endpoint
+		for name, endpointURL := range zcbEndpointURLs {
+			if endpoint == "" && endpointURL == payload.URL {
+				endpoint = name
+			}
+		}
+
+		// Результат ждёт запрос пользователя: при сбое источника повторы с таймаутом на каждый лишь задержат
+		// ответ 503, поэтому задача проваливается сразу
+		result, err := companyDataProvider.Fetch(endpoint, payload.ID)
+		if errors.Is(err, errCompanyDataNotFound) {
+			return nil, &JobFailure{Code: jobFailureCompanyDataNotFound, Reason: err.Error()}
+		} else if err != nil {
+			return nil, &JobFailure{Code: jobFailureCompanyDataUnavailable, Reason: err.Error()}
+		}
+		return result, nil
+	})
+
+	RegisterJobHandler(jobTypeFindFieldsAI, func(job config.Job) ([]byte, error) {
+		var payload userSampleJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, err
+		}
+		db := config.DB()
+		var user config.User
+		if err := db.Where(config.User{ID: payload.UserID}).First(&user).Error; err != nil {
+			return nil, err
+		}
+		userSample, err := jobUserSample(db, user.ID, payload)
+		if err != nil {
+			return nil, &JobFailure{Code: "user_sample_not_found", Reason: err.Error()}
+		}
+		return nil, processFindRequiredFieldsAI(db, job.ID, userSample, user)
+	})
+	RegisterJobFailedHook(jobTypeFindFieldsAI, markFindRequiredFieldsAIFailed)
+
+	RegisterJobHandler(jobTypeScanOcr, func(job config.Job) ([]byte, error) {
+		var payload ocrJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, err
+		}
+		pdfBuffer, err := getPdfFromStorage(config.Storage(), payload.FileName)
+		if err != nil {
+			return nil, err
+		}
+		pages := 0
+		result := scanOcr(pdfBuffer.Bytes(), func(page int, total int) {
+			pages = page
+			reportJobStep(payload.ParentJobID, jobStepOCR, page, total, path.Base(payload.FileName))
+		})
+		if result == nil {
+			return nil, errors.New("OCR failed for " + payload.FileName)
+		}
+		// Страницы списываются только за успешное распознавание, неудачные попытки и повторы не оплачиваются
+		if payload.UserSampleID != 0 {
+			recordUserSampleUsage(config.DB(), payload.UserSampleID, payload.UserID, config.UsageOCRPages, pages)
+		}
+		return result, nil
+	})
+
+	RegisterJobHandler(jobTypeGPTRequest, func(job config.Job) ([]byte, error) {
+		var payload gptJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, err
+		}
+		if payload.Dir == "" {
+			payload.Dir = legacyUserSampleDir(payload.UserID, payload.SampleID)
+		}
+		// aiRequest возвращает текст ошибки вместо ответа; задача без результата повторяется
+		response := aiRequest([]string{payload.Chunk}, payload.Dir)
+		var completion struct {
+			Result struct {
+				Alternatives []json.RawMessage `json:"alternatives"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(response), &completion); err != nil || len(completion.Result.Alternatives) == 0 {
+			return nil, errors.New("YandexGPT returned no result: " + response)
+		}
+		return []byte(response), nil
+	})
+
+	RegisterJobHandler(jobTypeConvertDocx, func(job config.Job) ([]byte, error) {
+		var payload libreJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, err
+		}
+		buf, err := convertDocxToPDF(bytes.NewBuffer(payload.Docx))
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// StartQueues запускает обработчики очередей; задачи, прерванные перезапуском, подхватываются по истечении аренды
+func StartQueues() {
 	ZCBQueue.Run()
 	AIQueue.Run()
 	OCRQueue.Run()
 	GPTQueue.Run()
 	LibreQueue.Run()
+	MailQueue.Run()
+}
+
+type JobHandler func(job config.Job) ([]byte, error)
+
+// JobFailedHook вызывается, когда задача окончательно провалилась (после всех попыток или из-за прерывания)
+type JobFailedHook func(job config.Job)
+
+// JobFailure — ошибка задачи с машинным кодом; такие задачи не перезапускаются
+type JobFailure struct {
+	Code   string
+	Reason string
 }
 
-type JobFunc func() error
+func (f *JobFailure) Error() string {
+	return f.Code + ": " + f.Reason
+}
+
+var jobHandlers = make(map[string]JobHandler)
+var jobFailedHooks = make(map[string]JobFailedHook)
+
+func RegisterJobHandler(jobType string, handler JobHandler) {
+	jobHandlers[jobType] = handler
+}
+
+func RegisterJobFailedHook(jobType string, hook JobFailedHook) {
+	jobFailedHooks[jobType] = hook
+}
+
+func runJobFailedHook(job config.Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Ошибка в обработчике неудачи job:", r)
+		}
+	}()
+
+	if hook, ok := jobFailedHooks[job.Type]; ok {
+		hook(job)
+	}
+}
 
 type JobQueue struct {
-	queue       chan JobFunc
+	name        string
+	maxAttempts int
 	rateLimiter <-chan time.Time
+	wake        chan struct{}
 }
 
-func NewJobQueue(rate time.Duration, bufferSize int) *JobQueue {
+func NewJobQueue(name string, rate time.Duration, maxAttempts int) *JobQueue {
 	return &JobQueue{
-		queue:       make(chan JobFunc, bufferSize),
+		name:        name,
+		maxAttempts: maxAttempts,
 		rateLimiter: time.Tick(rate),
+		wake:        make(chan struct{}, 1),
 	}
 }
 
-func (jq *JobQueue) Add(job JobFunc) {
-	jq.queue <- job
+// Enqueue сохраняет задачу в БД; в job задаются тип, владелец и Deadline — момент, после которого результат уже никому не нужен
+func (jq *JobQueue) Enqueue(job config.Job, payload interface{}) (config.Job, error) {
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return config.Job{}, err
+	}
+
+	job.Queue = jq.name
+	job.Payload = payloadJson
+	job.Status = config.JobPending
+	job.MaxAttempts = jq.maxAttempts
+	job.RunAt = time.Now()
+	if err := config.DB().Create(&job).Error; err != nil {
+		return config.Job{}, err
+	}
+
+	select {
+	case jq.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// EnqueueAndWait ставит задачу и блокируется до её завершения (все попытки, включая повторы)
+func (jq *JobQueue) EnqueueAndWait(job config.Job, payload interface{}) ([]byte, error) {
+	deadline := time.Now().Add(jobSyncWaitTimeout)
+	job.Deadline = &deadline
+	job, err := jq.Enqueue(job, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err = waitJob(job.ID, deadline)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != config.JobSucceeded {
+		// Код отказа сохраняем, чтобы вызывающий мог отличить его от сбоя
+		if job.FailureCode != "" && job.FailureCode != "internal_error" {
+			return nil, &JobFailure{Code: job.FailureCode, Reason: job.FailureReason}
+		}
+		return nil, errors.New(job.LastError)
+	}
+	return job.Result, nil
 }
 
 func (jq *JobQueue) Run() {
 	go func() {
-		for job := range jq.queue {
-			<-jq.rateLimiter
-			err := job()
+		for {
+			job, err := jq.lease()
 			if err != nil {
-				fmt.Println("Ошибка при выполнении job:", err)
+				fmt.Println("Ошибка при получении job:", err)
+			}
+			if job == nil {
+				select {
+				case <-jq.wake:
+				case <-time.After(jobIdlePollInterval):
+				}
+				continue
+			}
+
+			<-jq.rateLimiter
+			jq.execute(*job)
+		}
+	}()
+}
+
+// lease атомарно забирает следующую готовую задачу очереди с наибольшим приоритетом: новую или брошенную упавшим процессом
+func (jq *JobQueue) lease() (*config.Job, error) {
+	db := config.DB()
+
+	for {
+		now := time.Now()
+		var leased *config.Job
+		var expired *config.Job
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var jobs []config.Job
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("queue = ? AND ((status = ? AND run_at <= ?) OR (status = ? AND leased_until < ?))",
+					jq.name, config.JobPending, now, config.JobRunning, now).
+				Order("priority desc, run_at").
+				Limit(1).
+				Find(&jobs).Error
+			if err != nil || len(jobs) == 0 {
+				return err
+			}
+
+			job := jobs[0]
+			if job.Deadline != nil && job.Deadline.Before(now) {
+				job.Status = config.JobFailed
+				job.LastError = jobDeadlineExpiredMsg
+				job.FailureCode = "deadline_expired"
+				job.FailureReason = jobDeadlineExpiredMsg
+				job.LeasedUntil = nil
+				job.FinishedAt = &now
+				expired = &job
+				return tx.Save(&job).Error
+			}
+			if job.Status == config.JobRunning && job.Attempts >= job.MaxAttempts {
+				job.Status = config.JobFailed
+				job.LastError = "процесс завершился во время выполнения задачи"
+				job.FailureCode = "interrupted"
+				job.FailureReason = job.LastError
+				job.LeasedUntil = nil
+				job.FinishedAt = &now
+				expired = &job
+				return tx.Save(&job).Error
+			}
+
+			leasedUntil := now.Add(jobLeaseDuration)
+			job.Status = config.JobRunning
+			job.Attempts++
+			job.LeasedUntil = &leasedUntil
+			if job.StartedAt == nil {
+				job.StartedAt = &now
+			}
+			if err := tx.Save(&job).Error; err != nil {
+				return err
+			}
+			leased = &job
+			return nil
+		})
+
+		// Просроченную задачу закрыли — сразу смотрим следующую
+		if err == nil && expired != nil {
+			notifyJobWaiter(expired.ID)
+			runJobFailedHook(*expired)
+			continue
+		}
+		return leased, err
+	}
+}
+
+func (jq *JobQueue) execute(job config.Job) {
+	db := config.DB()
+
+	// Продлеваем аренду, пока задача выполняется, чтобы её не забрал другой обработчик
+	stopHeartbeat := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobLeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopHeartbeat:
+				return
+			case <-ticker.C:
+				db.Model(&config.Job{}).Where("id = ?", job.ID).Update("leased_until", time.Now().Add(jobLeaseDuration))
 			}
 		}
 	}()
+
+	result, err := runJobHandler(job)
+	close(stopHeartbeat)
+
+	// Шаг и прогресс пишет сам обработчик — перечитываем, чтобы не затереть их
+	var current config.Job
+	if errReload := db.First(&current, job.ID).Error; errReload == nil {
+		job.Step, job.StepCurrent, job.StepTotal, job.StepDetail = current.Step, current.StepCurrent, current.StepTotal, current.StepDetail
+	}
+
+	now := time.Now()
+	var failure *JobFailure
+	job.LeasedUntil = nil
+	if err == nil {
+		job.Status = config.JobSucceeded
+		job.Result = result
+		job.LastError = ""
+		job.FinishedAt = &now
+	} else if errors.As(err, &failure) || job.Attempts >= job.MaxAttempts {
+		job.Status = config.JobFailed
+		job.LastError = err.Error()
+		job.FinishedAt = &now
+		if failure != nil {
+			job.FailureCode = failure.Code
+			job.FailureReason = failure.Reason
+		} else {
+			job.FailureCode = "internal_error"
+			job.FailureReason = err.Error()
+		}
+		fmt.Println("Ошибка при выполнении job:", err)
+	} else {
+		job.Status = config.JobPending
+		job.RunAt = time.Now().Add(jobRetryDelay(job.Attempts))
+		job.LastError = err.Error()
+	}
+
+	if errSave := db.Save(&job).Error; errSave != nil {
+		fmt.Println("Ошибка при сохранении job:", errSave)
+	}
+
+	if job.Finished() {
+		notifyJobWaiter(job.ID)
+	}
+	if job.Status == config.JobFailed {
+		runJobFailedHook(job)
+	}
+}
+
+func runJobHandler(job config.Job) (result []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	handler, ok := jobHandlers[job.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %s", job.Type)
+	}
+	return handler(job)
+}
+
+func jobRetryDelay(attempts int) time.Duration {
+	delay := jobRetryBaseDelay << (attempts - 1)
+	if delay <= 0 || delay > jobRetryMaxDelay {
+		return jobRetryMaxDelay
+	}
+	return delay
+}
+
+// ----------WAITERS----------
+
+var jobWaiters = struct {
+	sync.Mutex
+	m map[int]chan struct{}
+}{m: make(map[int]chan struct{})}
+
+func notifyJobWaiter(jobId int) {
+	jobWaiters.Lock()
+	defer jobWaiters.Unlock()
+
+	if ch, ok := jobWaiters.m[jobId]; ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waitJob ждёт завершения задачи; задачу могли выполнить и в другом экземпляре сервиса, поэтому БД опрашивается периодически
+func waitJob(jobId int, deadline time.Time) (config.Job, error) {
+	ch := make(chan struct{}, 1)
+	jobWaiters.Lock()
+	jobWaiters.m[jobId] = ch
+	jobWaiters.Unlock()
+
+	defer func() {
+		jobWaiters.Lock()
+		delete(jobWaiters.m, jobId)
+		jobWaiters.Unlock()
+	}()
+
+	ticker := time.NewTicker(jobWaitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var job config.Job
+		if err := config.DB().First(&job, jobId).Error; err != nil {
+			return job, err
+		}
+		if job.Finished() {
+			return job, nil
+		}
+		if time.Now().After(deadline) {
+			return job, errors.New(jobDeadlineExpiredMsg)
+		}
+
+		select {
+		case <-ch:
+		case <-ticker.C:
+		}
+	}
 }
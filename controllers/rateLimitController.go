@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"park/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitSweepInterval     = time.Hour
+	rateLimitAllowlistCacheTTL = time.Minute
+)
+
+// RateLimitStore — хранилище счётчиков ограничителя запросов. Окна фиксированные, скользящее окно
+// оценивается по текущему и предыдущему
+type RateLimitStore interface {
+	// Hit учитывает запрос subject к endpoint в окне windowStart и возвращает число запросов в этом окне
+	// (вместе с текущим) и в предыдущем
+	Hit(endpoint string, subject string, windowStart time.Time, window time.Duration) (current int, previous int, err error)
+	// Sweep удаляет окна endpoint, начавшиеся раньше before
+	Sweep(endpoint string, before time.Time) error
+}
+
+var rateLimitStore RateLimitStore
+
+// InitRateLimitStore выбирает хранилище по RATE_LIMIT_STORE: postgres (по умолчанию) — общее для всех
+// экземпляров сервиса, memory — в памяти процесса, для разработки
+func InitRateLimitStore() {
+	store, _ := os.LookupEnv("RATE_LIMIT_STORE")
+
+	switch store {
+	case "", "postgres":
+		rateLimitStore = &postgresRateLimitStore{}
+	case "memory":
+		rateLimitStore = &memoryRateLimitStore{counters: make(map[string]int)}
+	default:
+		panic(fmt.Sprintf("Unknown rate limit store %s", store))
+	}
+}
+
+// ClientIPExtractor определяет адрес клиента для ограничителя запросов. X-Forwarded-For учитывается, только
+// если запрос пришёл от прокси из TRUSTED_PROXIES (CIDR через запятую); без них берётся адрес соединения —
+// иначе клиент подставит в заголовок любой адрес и обойдёт ограничение
+func ClientIPExtractor() echo.IPExtractor {
+	value, _ := os.LookupEnv("TRUSTED_PROXIES")
+	if strings.TrimSpace(value) == "" {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range strings.Split(value, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			panic(fmt.Sprintf("Invalid TRUSTED_PROXIES range %s", cidr))
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+type postgresRateLimitStore struct{}
+
+func (s *postgresRateLimitStore) Hit(endpoint string, subject string, windowStart time.Time, window time.Duration) (int, int, error) {
+	db := config.DB()
+
+	// Увеличение и чтение счётчика — один запрос, поэтому экземпляры сервиса не теряют запросы друг друга
+	counter := config.RateLimitCounter{Endpoint: endpoint, Subject: subject, WindowStart: windowStart, Count: 1}
+	err := db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "endpoint"}, {Name: "subject"}, {Name: "window_start"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("rate_limit_counters.count + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "count"}}},
+	).Create(&counter).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var previous config.RateLimitCounter
+	db.Where(config.RateLimitCounter{Endpoint: endpoint, Subject: subject, WindowStart: windowStart.Add(-window)}).
+		Limit(1).Find(&previous)
+
+	return counter.Count, previous.Count, nil
+}
+
+func (s *postgresRateLimitStore) Sweep(endpoint string, before time.Time) error {
+	return config.DB().Where("endpoint = ? AND window_start < ?", endpoint, before).Delete(&config.RateLimitCounter{}).Error
+}
+
+type memoryRateLimitStore struct {
+	sync.Mutex
+	counters map[string]int
+}
+
+func memoryRateLimitKey(endpoint string, subject string, windowStart time.Time) string {
+	return endpoint + "|" + strconv.FormatInt(windowStart.Unix(), 10) + "|" + subject
+}
+
+func (s *memoryRateLimitStore) Hit(endpoint string, subject string, windowStart time.Time, window time.Duration) (int, int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := memoryRateLimitKey(endpoint, subject, windowStart)
+	s.counters[key]++
+	return s.counters[key], s.counters[memoryRateLimitKey(endpoint, subject, windowStart.Add(-window))], nil
+}
+
+func (s *memoryRateLimitStore) Sweep(endpoint string, before time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for key := range s.counters {
+		parts := strings.SplitN(key, "|", 3)
+		start, _ := strconv.ParseInt(parts[1], 10, 64)
+		if parts[0] == endpoint && time.Unix(start, 0).Before(before) {
+			delete(s.counters, key)
+		}
+	}
+	return nil
+}
+
+// StartRateLimitSweeper периодически удаляет окна, которые уже не влияют на ограничения
+func StartRateLimitSweeper() {
+	go func() {
+		for {
+			for _, endpoint := range config.RateLimitEndpoints {
+				window := config.RateLimitFor(endpoint).Window
+				if err := rateLimitStore.Sweep(endpoint, time.Now().Add(-2*window)); err != nil {
+					fmt.Println("Ошибка очистки счётчиков ограничения запросов:", err)
+				}
+			}
+			time.Sleep(rateLimitSweepInterval)
+		}
+	}()
+}
+
+// rateLimit учитывает запрос клиента к endpoint и при превышении ограничения возвращает 429 с Retry-After.
+// Отклонённые запросы тоже учитываются — клиент, который продолжает слать запросы, ждёт дольше
+func rateLimit(c echo.Context, endpoint string) error {
+	limit := config.RateLimitFor(endpoint)
+	ip := c.RealIP()
+	if limit.Limit == 0 || rateLimitAllowlisted(ip) {
+		return nil
+	}
+
+	now := time.Now()
+	windowStart := now.Truncate(limit.Window)
+	current, previous, err := rateLimitStore.Hit(endpoint, ip, windowStart, limit.Window)
+	if err != nil {
+		// Без хранилища счётчиков лучше пропустить запрос, чем закрыть маршрут для всех
+		c.Logger().Error(err)
+		return nil
+	}
+
+	elapsed := now.Sub(windowStart)
+	estimate := float64(previous)*(1-float64(elapsed)/float64(limit.Window)) + float64(current)
+
+	remaining := limit.Limit - int(math.Ceil(estimate))
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Response().Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+	c.Response().Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if estimate <= float64(limit.Limit) {
+		return nil
+	}
+
+	retryAfter := int(math.Ceil(rateLimitRetryAfter(limit, current, previous, elapsed).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return NewAPIError(http.StatusTooManyRequests, "rate_limited").WithDetails(map[string]int{"retryAfter": retryAfter})
+}
+
+// rateLimitRetryAfter — через сколько следующий запрос уложится в ограничение, если до тех пор запросов не будет
+func rateLimitRetryAfter(limit config.RateLimit, current int, previous int, elapsed time.Duration) time.Duration {
+	window := float64(limit.Window)
+
+	// В текущем окне: вес предыдущего окна должен упасть так, чтобы осталось место для ещё одного запроса
+	if current+1 <= limit.Limit {
+		if previous == 0 {
+			return 0
+		}
+		needed := window * (1 - float64(limit.Limit-current-1)/float64(previous))
+		return time.Duration(needed) - elapsed
+	}
+
+	// Иначе ждать следующего окна, где текущее станет предыдущим
+	needed := window * (1 - float64(limit.Limit-1)/float64(current))
+	return limit.Window - elapsed + time.Duration(needed)
+}
+
+var rateLimitAllowlistCache struct {
+	sync.RWMutex
+	networks []*net.IPNet
+	loadedAt time.Time
+}
+
+// parseAllowlistAddress принимает адрес или подсеть в нотации CIDR
+func parseAllowlistAddress(address string) (*net.IPNet, error) {
+	if ip := net.ParseIP(address); ip != nil {
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(address)
+	return network, err
+}
+
+func rateLimitAllowlisted(ip string) bool {
+	rateLimitAllowlistCache.RLock()
+	networks := rateLimitAllowlistCache.networks
+	fresh := time.Since(rateLimitAllowlistCache.loadedAt) < rateLimitAllowlistCacheTTL
+	rateLimitAllowlistCache.RUnlock()
+
+	if !fresh {
+		var rows []config.RateLimitAllowlist
+		if err := config.DB().Find(&rows).Error; err == nil {
+			networks = nil
+			for _, row := range rows {
+				if network, err := parseAllowlistAddress(row.Address); err == nil {
+					networks = append(networks, network)
+				}
+			}
+
+			rateLimitAllowlistCache.Lock()
+			rateLimitAllowlistCache.networks = networks
+			rateLimitAllowlistCache.loadedAt = time.Now()
+			rateLimitAllowlistCache.Unlock()
+		}
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidateRateLimitAllowlist() {
+	rateLimitAllowlistCache.Lock()
+	rateLimitAllowlistCache.loadedAt = time.Time{}
+	rateLimitAllowlistCache.Unlock()
+}
+
+func ListRateLimitAllowlist(c echo.Context) error {
+	var rows []config.RateLimitAllowlist
+	if err := config.DB().Order("id").Find(&rows).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, rows)
+}
+
+func AddRateLimitAllowlist(c echo.Context) error {
+	db := config.DB()
+
+	address := strings.TrimSpace(c.Request().Header.Get("address"))
+	if address == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "address"})
+	}
+	if _, err := parseAllowlistAddress(address); err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "address"})
+	}
+
+	user := contextUser(c)
+	row := config.RateLimitAllowlist{Address: address, Note: c.Request().Header.Get("note"), CreatedBy: user.ID}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "address"}},
+		DoUpdates: clause.AssignmentColumns([]string{"note"}),
+	}).Create(&row).Error
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+	invalidateRateLimitAllowlist()
+
+	AddLog(user.ID, "Add RateLimitAllowlist", address)
+	return c.JSON(http.StatusOK, row)
+}
+
+func RemoveRateLimitAllowlist(c echo.Context) error {
+	db := config.DB()
+
+	address := strings.TrimSpace(c.Request().Header.Get("address"))
+	if address == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "address"})
+	}
+
+	res := db.Where(config.RateLimitAllowlist{Address: address}).Delete(&config.RateLimitAllowlist{})
+	if res.Error != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", res.Error)
+	} else if res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+	invalidateRateLimitAllowlist()
+
+	AddLog(contextUser(c).ID, "Remove RateLimitAllowlist", address)
+	return c.JSON(http.StatusOK, nil)
+}
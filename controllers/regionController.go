@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"park/config"
+	"strconv"
+	"strings"
+)
+
+// userRegions возвращает регионы модератора; all == true — пользователь не ограничен регионами
+func userRegions(user config.User) (regions []string, all bool) {
+	if userCan(user, config.PermRegionAll) {
+		return nil, true
+	}
+
+	var rows []config.ModeratorRegion
+	config.DB().Where(config.ModeratorRegion{UserID: user.ID}).Find(&rows)
+
+	regions = []string{}
+	for _, row := range rows {
+		regions = append(regions, normalizePlaceName(row.Region))
+	}
+	return regions, false
+}
+
+func canAccessRegion(user config.User, region string) bool {
+	regions, all := userRegions(user)
+	if all {
+		return true
+	}
+
+	region = normalizePlaceName(region)
+	for _, r := range regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+func canAccessDecree(user config.User, decreeId int) bool {
+	var decree config.Decree
+	if err := config.DB().First(&decree, decreeId).Error; err != nil {
+		return false
+	}
+	return canAccessRegion(user, decree.Region)
+}
+
+func canAccessGrant(user config.User, grantId int) bool {
+	var grant config.Grant
+	if err := config.DB().First(&grant, grantId).Error; err != nil {
+		return false
+	}
+	return canAccessDecree(user, grant.DecreeID)
+}
+
+// scopeDecreesByRegion ограничивает выборку Decree регионами пользователя
+func scopeDecreesByRegion(query *gorm.DB, user config.User) *gorm.DB {
+	regions, all := userRegions(user)
+	if all {
+		return query
+	}
+	if len(regions) == 0 {
+		return query.Where("1 = 0")
+	}
+	decreeIds := config.DB().Model(&config.DecreeIndex{}).Select("decree_id").Where("region IN ?", regions)
+	return query.Where("id IN (?)", decreeIds)
+}
+
+func scopeGrantsByRegion(query *gorm.DB, user config.User) *gorm.DB {
+	if _, all := userRegions(user); all {
+		return query
+	}
+	decreeIds := scopeDecreesByRegion(config.DB().Model(&config.Decree{}).Select("id"), user)
+	return query.Where("decree_id IN (?)", decreeIds)
+}
+
+func scopeSamplesByRegion(query *gorm.DB, user config.User) *gorm.DB {
+	if _, all := userRegions(user); all {
+		return query
+	}
+	grantIds := scopeGrantsByRegion(config.DB().Model(&config.Grant{}).Select("id"), user)
+	return query.Where("grant_id IN (?)", grantIds)
+}
+
+func regionForbidden(c echo.Context) error {
+	return NewAPIError(http.StatusForbidden, "region_forbidden")
+}
+
+func ListModeratorRegions(c echo.Context) error {
+	db := config.DB()
+
+	userId := c.Request().Header.Get("userId")
+
+	query := db.Order("user_id, region")
+	if userId != "" {
+		userIdInt, err := strconv.Atoi(userId)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "userId"})
+		}
+		query = query.Where(config.ModeratorRegion{UserID: userIdInt})
+	}
+
+	var regions []config.ModeratorRegion
+	if err := query.Find(&regions).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, regions)
+}
+
+func AssignModeratorRegion(c echo.Context) error {
+	db := config.DB()
+
+	userId, err := strconv.Atoi(c.Request().Header.Get("userId"))
+	region := strings.TrimSpace(c.QueryParam("region"))
+	if err != nil || region == "" {
+		return NewAPIError(http.StatusBadRequest, "invalid_params")
+	}
+
+	var moderator config.User
+	res := db.Where(config.User{ID: userId}).First(&moderator)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "user_not_found")
+	}
+
+	row := config.ModeratorRegion{UserID: userId, Region: region}
+	if err := db.Where(row).FirstOrCreate(&row).Error; err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+
+	AddLog(contextUser(c).ID, "Assign moderator region", strconv.Itoa(userId)+": "+region)
+
+	return c.JSON(http.StatusOK, row)
+}
+
+func UnassignModeratorRegion(c echo.Context) error {
+	db := config.DB()
+
+	userId, err := strconv.Atoi(c.Request().Header.Get("userId"))
+	region := strings.TrimSpace(c.QueryParam("region"))
+	if err != nil || region == "" {
+		return NewAPIError(http.StatusBadRequest, "invalid_params")
+	}
+
+	// Регион сравнивается так же, как при проверке доступа: «москва» снимает «г. Москва»
+	var rows []config.ModeratorRegion
+	if err := db.Where(config.ModeratorRegion{UserID: userId}).Find(&rows).Error; err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+	ids := []int{}
+	for _, row := range rows {
+		if normalizePlaceName(row.Region) == normalizePlaceName(region) {
+			ids = append(ids, row.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+
+	if err := db.Where("id IN ?", ids).Delete(&config.ModeratorRegion{}).Error; err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+
+	AddLog(contextUser(c).ID, "Unassign moderator region", strconv.Itoa(userId)+": "+region)
+
+	return c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"io"
+	"strings"
+)
+
+// Предел размера сущности в теле запроса (списки ОКВЭД, тексты инструкций)
+const maxEntityBodySize = 10 << 20
+
+// FieldError — ошибка проверки одного поля сущности
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError — ошибки проверки сущности из запроса; клиенту уходит в details ошибки validation_failed
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Add(field string, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err возвращает nil, если ошибок нет, — чтобы не получить ненулевой error с пустым списком
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// readEntity достаёт JSON сущности из части multipart-формы part (поле или файл) или из JSON-тела.
+// На переходный период поддерживается старый способ — JSON в заголовке legacyHeader.
+func readEntity(c echo.Context, part string, legacyHeader string) ([]byte, error) {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+
+	switch {
+	case strings.HasPrefix(contentType, echo.MIMEMultipartForm):
+		if value := c.FormValue(part); value != "" {
+			return []byte(value), nil
+		}
+		if file, err := c.FormFile(part); err == nil {
+			src, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer src.Close()
+			return io.ReadAll(io.LimitReader(src, maxEntityBodySize))
+		}
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxEntityBodySize))
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			return body, nil
+		}
+	}
+
+	if value := c.Request().Header.Get(legacyHeader); value != "" {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+// bindEntity разбирает сущность из запроса в dst; ошибки разбора возвращаются как *ValidationError
+func bindEntity(c echo.Context, part string, legacyHeader string, dst interface{}) error {
+	validation := &ValidationError{}
+
+	data, err := readEntity(c, part, legacyHeader)
+	if err != nil {
+		validation.Add(part, "не удалось прочитать: "+err.Error())
+		return validation
+	} else if data == nil {
+		validation.Add(part, "обязательное поле")
+		return validation
+	}
+
+	if err := json.Unmarshal(data, dst); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			validation.Add(typeErr.Field, "ожидается значение типа "+typeErr.Type.String())
+		} else {
+			validation.Add(part, "некорректный JSON: "+err.Error())
+		}
+		return validation
+	}
+
+	return nil
+}
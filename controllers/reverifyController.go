@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"park/config"
+	"strings"
+	"time"
+)
+
+const (
+	companyReverifyPollInterval = time.Hour
+	companyReverifyBatch        = 500
+	jobTypeReverifyCompany      = "reverifyCompany"
+)
+
+type reverifyCompanyJobPayload struct {
+	CompanyID int `json:"companyId"`
+}
+
+func init() {
+	// Задача выполняется в ZCBQueue с фоновым приоритетом и сама соблюдает её темп, поэтому запросы
+	// к источнику идут напрямую: ожидание вложенной задачи той же очереди заблокировало бы её
+	RegisterJobHandler(jobTypeReverifyCompany, func(job config.Job) ([]byte, error) {
+		var payload reverifyCompanyJobPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, err
+		}
+		return nil, reverifyCompany(payload.CompanyID)
+	})
+}
+
+// StartCompanyReverification периодически ставит в ZCBQueue перепроверку компаний, у которых подошёл срок.
+// Интерактивные проверки CheckCompany идут в той же очереди раньше перепроверок
+func StartCompanyReverification() {
+	go func() {
+		for {
+			scheduleCompanyReverification()
+			time.Sleep(companyReverifyPollInterval)
+		}
+	}()
+}
+
+func scheduleCompanyReverification() {
+	db := config.DB()
+	now := time.Now()
+
+	// Новые компании прошли CheckCompany только что — первая перепроверка через полный период
+	var newCompanyIds []int
+	db.Model(&config.Company{}).
+		Where("id NOT IN (?)", db.Model(&config.CompanyStatus{}).Select("company_id")).
+		Pluck("id", &newCompanyIds)
+	for _, companyId := range newCompanyIds {
+		status := config.CompanyStatus{CompanyID: companyId, Eligible: true, NextCheckAt: now.Add(config.CompanyReverifyPeriod())}
+		db.Clauses(clause.OnConflict{DoNothing: true}).Create(&status)
+	}
+
+	var due []config.CompanyStatus
+	if err := db.Where("next_check_at <= ?", now).Order("next_check_at").Limit(companyReverifyBatch).Find(&due).Error; err != nil {
+		fmt.Println("Ошибка планирования перепроверки компаний:", err)
+		return
+	}
+
+	for _, status := range due {
+		// Условие по next_check_at не даёт другому экземпляру сервиса поставить ту же компанию повторно
+		res := db.Model(&config.CompanyStatus{}).
+			Where("id = ? AND next_check_at = ?", status.ID, status.NextCheckAt).
+			Update("next_check_at", now.Add(config.CompanyReverifyPeriod()))
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+
+		job := config.Job{Type: jobTypeReverifyCompany, Priority: jobPriorityBackground}
+		if _, err := ZCBQueue.Enqueue(job, reverifyCompanyJobPayload{CompanyID: status.CompanyID}); err != nil {
+			fmt.Println("Ошибка постановки перепроверки компании:", err)
+		}
+	}
+}
+
+// reverifyCompany обновляет данные компании, заново применяет общие правила и, если компания
+// перестала им соответствовать, отмечает это и уведомляет её пользователей
+func reverifyCompany(companyId int) error {
+	db := config.DB()
+
+	var company config.Company
+	if err := db.First(&company, companyId).Error; err != nil {
+		return &JobFailure{Code: "company_not_found", Reason: err.Error()}
+	}
+
+	// Каждый запрос ждёт такта очереди, как отдельная задача ZCB: первый такт задача уже заняла сама
+	fetch := func(key string, endpoint string) ([]byte, error) {
+		<-ZCBQueue.rateLimiter
+		return companyDataProvider.Fetch(endpoint, key)
+	}
+
+	sources := companySources{}
+	for _, request := range []struct {
+		endpoint string
+		key      string
+		dst      *[]byte
+	}{
+		{config.ZCBEndpointCard, company.INN, &sources.Card},
+		{config.ZCBEndpointFssp, company.OGRN, &sources.Fssp},
+		{config.ZCBEndpointFns, company.OGRN, &sources.Fns},
+	} {
+		// В обход кеша: перепроверка должна видеть текущие данные, даже если TTL кеша больше периода перепроверки
+		data, err := loadCompanyData(company.INN, request.key, request.endpoint, true, fetch)
+		if errors.Is(err, errCompanyDataNotFound) {
+			return &JobFailure{Code: jobFailureCompanyDataNotFound, Reason: err.Error()}
+		} else if err != nil {
+			// Источник недоступен — это не повод считать компанию неподходящей; задача повторится
+			return err
+		}
+		*request.dst = data
+	}
+
+	rules, err := loadEligibilityRules(nil)
+	if err != nil {
+		return err
+	}
+	outcomes, rejects := evaluateEligibility(rules, sources)
+
+	check := config.EligibilityCheck{
+		INN:      company.INN,
+		OGRN:     company.OGRN,
+		CardData: sources.Card,
+		FsspData: sources.Fssp,
+		FnsData:  sources.Fns,
+	}
+	if err := saveEligibilityCheck(&check, outcomes, rejects); err != nil {
+		return err
+	}
+
+	var status config.CompanyStatus
+	db.Where(config.CompanyStatus{CompanyID: company.ID}).Limit(1).Find(&status)
+	wasEligible := status.ID == 0 || status.Eligible
+
+	now := time.Now()
+	status.CompanyID = company.ID
+	status.Eligible = check.Eligible
+	status.LastCheckID = check.ID
+	status.VerifiedAt = &now
+	if status.NextCheckAt.IsZero() {
+		status.NextCheckAt = now.Add(config.CompanyReverifyPeriod())
+	}
+	if check.Eligible {
+		status.IneligibleSince = nil
+	} else if wasEligible {
+		status.IneligibleSince = &now
+	}
+	if err := db.Save(&status).Error; err != nil {
+		return err
+	}
+
+	if wasEligible && !check.Eligible {
+		AddLog(0, "Company became ineligible", company.INN)
+		notifyCompanyIneligible(company, rejects)
+	}
+	return nil
+}
+
+func notifyCompanyIneligible(company config.Company, rejects []string) {
+	var users []config.User
+	config.DB().Where("id IN ?", companyUserIds(company)).Find(&users)
+
+	text := "По результатам плановой проверки компания с ИНН " + company.INN +
+		" больше не соответствует условиям получения поддержки:\n\n- " + strings.Join(rejects, "\n- ") +
+		"\n\nПодбор грантов для компании приостановлен. Если данные изменятся, доступ восстановится после следующей проверки."
+
+	for _, user := range users {
+		if user.Email == "" {
+			continue
+		}
+		if err := SendMail(user.Email, "Компания не прошла плановую проверку", text, nil); err != nil {
+			fmt.Println("Ошибка отправки уведомления о проверке компании:", err)
+		}
+	}
+}
+
+// companyIneligibility возвращает ошибку для компании, отмеченной перепроверкой как неподходящая, иначе nil
+func companyIneligibility(companyId int) *APIError {
+	var status config.CompanyStatus
+	res := config.DB().Where(config.CompanyStatus{CompanyID: companyId}).Limit(1).Find(&status)
+	if res.RowsAffected == 0 || status.Eligible {
+		return nil
+	}
+
+	var check config.EligibilityCheck
+	var rejects []string
+	if err := config.DB().First(&check, status.LastCheckID).Error; err == nil {
+		json.Unmarshal(check.Rejects, &rejects)
+	}
+	return NewAPIError(http.StatusLocked, "company_not_eligible").WithDetails(rejects)
+}
+
+func ListCompanyStatuses(c echo.Context) error {
+	db := config.DB()
+
+	query := db.Order("company_id")
+	if c.Request().Header.Get("ineligible") == "true" {
+		query = query.Where("eligible = ?", false)
+	}
+
+	var statuses []config.CompanyStatus
+	if err := query.Find(&statuses).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, statuses)
+}
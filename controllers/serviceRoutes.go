@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"park/config"
+)
+
+// AddServiceRoutes регистрирует маршруты фоновых задач и служебных API без префикса — для клиентов, которые
+// ещё не перешли на /api/v1. Новые маршруты добавляются только в apiV1Routes
+func AddServiceRoutes(e *echo.Echo) {
+	users := []echo.MiddlewareFunc{RequireUser}
+
+	e.POST("/createSession", CreateSession, users...)
+	e.POST("/refreshSession", RefreshSession)
+	e.POST("/logout", Logout, users...)
+	e.POST("/logoutAll", LogoutAll, users...)
+
+	e.GET("/getAIJobStatus", GetAIJobStatus, users...)
+	e.POST("/retryFindRequiredFieldsAI", RetryFindRequiredFieldsAI, users...)
+
+	e.GET("/getUserSampleHistory", GetUserSampleHistory, users...)
+	e.POST("/rewindUserSample", RewindUserSample, RequirePermission(config.PermUserSampleRewind))
+
+	e.GET("/listRolePermissions", ListRolePermissions, RequirePermission(config.PermPermissionsManage))
+	e.POST("/grantRolePermission", GrantRolePermission, RequirePermission(config.PermPermissionsManage))
+	e.POST("/revokeRolePermission", RevokeRolePermission, RequirePermission(config.PermPermissionsManage))
+
+	e.GET("/listModeratorRegions", ListModeratorRegions, RequirePermission(config.PermModeratorsManage))
+	e.POST("/assignModeratorRegion", AssignModeratorRegion, RequirePermission(config.PermModeratorsManage))
+	e.POST("/unassignModeratorRegion", UnassignModeratorRegion, RequirePermission(config.PermModeratorsManage))
+
+	e.GET("/listLogs", ListLogs, RequirePermission(config.PermLogsRead))
+}
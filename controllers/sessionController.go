@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"park/config"
+	"strconv"
+	"time"
+)
+
+const tokenSweepInterval = time.Hour
+
+type sessionTokens struct {
+	AccessToken      string    `json:"accessToken"`
+	AccessExpiresAt  time.Time `json:"accessExpiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+}
+
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueSession выпускает пару короткий accessToken + refreshToken
+func issueSession(tx *gorm.DB, userId int) (sessionTokens, error) {
+	now := time.Now()
+
+	accessToken, err := generateToken()
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	refreshToken, err := generateToken()
+	if err != nil {
+		return sessionTokens{}, err
+	}
+
+	tokens := sessionTokens{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  now.Add(config.SessionAccessTokenLifetime),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: now.AddDate(0, 0, config.RefreshTokenValidality),
+	}
+
+	// ValidTrough — дата, поэтому берём следующий день; точный срок проверяется по RefreshToken.AccessExpiresAt
+	token := config.Token{
+		AccessToken: accessToken,
+		UserID:      userId,
+		ValidTrough: pgtype.Date{Time: tokens.AccessExpiresAt.AddDate(0, 0, 1), Valid: true},
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return sessionTokens{}, err
+	}
+
+	session := config.RefreshToken{
+		TokenHash:       hashRefreshToken(refreshToken),
+		UserID:          userId,
+		AccessTokenID:   token.ID,
+		AccessExpiresAt: tokens.AccessExpiresAt,
+		ExpiresAt:       tokens.RefreshExpiresAt,
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return sessionTokens{}, err
+	}
+
+	return tokens, nil
+}
+
+// revokeUserSessions удаляет все accessToken пользователя и отзывает его refreshToken
+func revokeUserSessions(tx *gorm.DB, userId int) error {
+	if err := tx.Where(config.Token{UserID: userId}).Delete(&config.Token{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&config.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+// CreateSession обменивает accessToken, полученный при входе, на пару короткий accessToken + refreshToken
+func CreateSession(c echo.Context) error {
+	db := config.DB()
+	user := contextUser(c)
+	accessToken := c.Request().Header.Get("accessToken")
+
+	var tokens sessionTokens
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(config.Token{AccessToken: accessToken, UserID: user.ID}).Delete(&config.Token{}).Error; err != nil {
+			return err
+		}
+		var err error
+		tokens, err = issueSession(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// RefreshSession выдаёт новую пару токенов по refreshToken; старый refreshToken отзывается.
+// Повторное использование отозванного refreshToken означает утечку — отзываются все сессии пользователя.
+func RefreshSession(c echo.Context) error {
+	db := config.DB()
+	refreshToken := c.Request().Header.Get("refreshToken")
+	if refreshToken == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "refreshToken"})
+	}
+
+	var session config.RefreshToken
+	res := db.Where(config.RefreshToken{TokenHash: hashRefreshToken(refreshToken)}).Limit(1).Find(&session)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusUnauthorized, "session_invalid")
+	}
+
+	if session.RevokedAt != nil {
+		revokeUserSessions(db, session.UserID)
+		AddLog(session.UserID, "Refresh token reuse", strconv.Itoa(session.ID))
+		return NewAPIError(http.StatusUnauthorized, "session_revoked")
+	} else if session.ExpiresAt.Before(time.Now()) {
+		return NewAPIError(http.StatusUnauthorized, "session_expired")
+	}
+
+	var user config.User
+	res = db.Where(config.User{ID: session.UserID}).First(&user)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusUnauthorized, "session_invalid")
+	} else if user.IsSuspended {
+		return NewAPIError(http.StatusForbidden, "user_suspended")
+	}
+
+	var tokens sessionTokens
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Условие по revoked_at защищает от двух параллельных обновлений одним refreshToken
+		res := tx.Model(&config.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", session.ID).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		} else if res.RowsAffected == 0 {
+			return fmt.Errorf("refresh token %d already used", session.ID)
+		}
+
+		if err := tx.Delete(&config.Token{}, session.AccessTokenID).Error; err != nil {
+			return err
+		}
+
+		var err error
+		tokens, err = issueSession(tx, session.UserID)
+		return err
+	})
+	if err != nil {
+		return NewAPIError(http.StatusUnauthorized, "session_invalid").WithCause(err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Logout отзывает текущий accessToken и связанный с ним refreshToken
+func Logout(c echo.Context) error {
+	db := config.DB()
+	user := contextUser(c)
+	accessToken := c.Request().Header.Get("accessToken")
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var token config.Token
+		res := tx.Where(config.Token{AccessToken: accessToken, UserID: user.ID}).Limit(1).Find(&token)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		if err := tx.Model(&config.RefreshToken{}).
+			Where("access_token_id = ? AND revoked_at IS NULL", token.ID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&token, token.ID).Error
+	})
+	if err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Сессия завершена"})
+}
+
+// LogoutAll завершает все сессии пользователя; с правом session:revoke можно указать чужой userId
+func LogoutAll(c echo.Context) error {
+	db := config.DB()
+	user := contextUser(c)
+
+	userId := user.ID
+	if target := c.Request().Header.Get("userId"); target != "" {
+		if !userCan(user, config.PermSessionRevoke) {
+			return permissionDenied(user)
+		}
+		targetId, err := strconv.Atoi(target)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "userId"})
+		}
+		userId = targetId
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return revokeUserSessions(tx, userId)
+	}); err != nil {
+		return NewAPIError(http.StatusInternalServerError, "database_error").WithCause(err)
+	}
+
+	AddLog(user.ID, "Logout all sessions", strconv.Itoa(userId))
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Все сессии завершены"})
+}
+
+// StartTokenSweeper периодически удаляет просроченные токены
+func StartTokenSweeper() {
+	go func() {
+		for {
+			sweepTokens()
+			time.Sleep(tokenSweepInterval)
+		}
+	}()
+}
+
+func sweepTokens() {
+	db := config.DB()
+	now := time.Now()
+
+	expiredSessions := db.Model(&config.RefreshToken{}).Select("access_token_id").Where("access_expires_at < ?", now)
+	if err := db.Where("valid_trough < ? OR id IN (?)", now, expiredSessions).Delete(&config.Token{}).Error; err != nil {
+		fmt.Println("Ошибка очистки токенов:", err)
+	}
+
+	if err := db.Where("expires_at < ?", now).Delete(&config.RefreshToken{}).Error; err != nil {
+		fmt.Println("Ошибка очистки refresh-токенов:", err)
+	}
+}
@@ -13,8 +13,6 @@ func SendMail(to string, subject string, text string, files []struct {
 	Name string
 	Data []byte
 }) error {
-	password, _ := os.LookupEnv("SMTP_PASSWORD")
-
 	e := email.NewEmail()
 	e.From = "Финтехник <noreply@fintechnik.online>"
 	e.To = []string{to}
@@ -30,6 +28,26 @@ func SendMail(to string, subject string, text string, files []struct {
 		}
 	}
 
+	return sendEmail(e)
+}
+
+// SendMailWithHeaders отправляет письмо с дополнительными заголовками, например List-Unsubscribe
+func SendMailWithHeaders(to string, subject string, text string, headers map[string]string) error {
+	e := email.NewEmail()
+	e.From = "Финтехник <noreply@fintechnik.online>"
+	e.To = []string{to}
+	e.Subject = subject
+	e.Text = []byte(text)
+	for key, value := range headers {
+		e.Headers.Set(key, value)
+	}
+
+	return sendEmail(e)
+}
+
+func sendEmail(e *email.Email) error {
+	password, _ := os.LookupEnv("SMTP_PASSWORD")
+
 	err := e.SendWithTLS("smtp.mail.ru:465",
 		smtp.PlainAuth("", "noreply@fintechnik.online", password, "smtp.mail.ru"),
 		&tls.Config{ServerName: "smtp.mail.ru"})
@@ -0,0 +1,370 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"net/http"
+	"park/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// activeCompanyHeader — заголовок выбора компании для маршрутов, работающих от имени компании пользователя
+var activeCompanyHeader = optionalHeader("companyINN", "ИНН компании, от имени которой выполняется запрос; по умолчанию — основная компания пользователя")
+
+// userCompanyRole возвращает роль пользователя в компании. Компания из User.CompanyINN считается
+// своей и без связи — связь владельца создаётся при первом обращении
+func userCompanyRole(user config.User, company config.Company) (string, bool) {
+	db := config.DB()
+
+	var link config.UserCompany
+	res := db.Where(config.UserCompany{UserID: user.ID, CompanyID: company.ID}).Limit(1).Find(&link)
+	if res.Error == nil && res.RowsAffected != 0 {
+		return link.Role, true
+	}
+
+	if user.CompanyINN == "" || user.CompanyINN != company.INN {
+		return "", false
+	}
+	link = config.UserCompany{UserID: user.ID, CompanyID: company.ID, Role: config.UserCompanyOwner}
+	db.Clauses(clause.OnConflict{DoNothing: true}).Create(&link)
+	return config.UserCompanyOwner, true
+}
+
+// activeCompany — компания, от имени которой пользователь выполняет запрос: из заголовка companyINN,
+// иначе из User.CompanyINN, иначе единственная связанная компания
+func activeCompany(c echo.Context) (config.Company, error) {
+	db := config.DB()
+	user := contextUser(c)
+
+	inn := c.Request().Header.Get("companyINN")
+	if inn == "" {
+		inn = user.CompanyINN
+	}
+
+	var company config.Company
+	if inn == "" {
+		var links []config.UserCompany
+		db.Where(config.UserCompany{UserID: user.ID}).Limit(2).Find(&links)
+		if len(links) != 1 {
+			return company, NewAPIError(http.StatusBadRequest, "company_required")
+		}
+		if err := db.First(&company, links[0].CompanyID).Error; err != nil {
+			return company, NewAPIError(http.StatusForbidden, "company_not_found")
+		}
+		return company, nil
+	}
+
+	res := db.Where(config.Company{INN: inn}).Limit(1).Find(&company)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return company, NewAPIError(http.StatusForbidden, "company_not_found")
+	}
+	if _, ok := userCompanyRole(user, company); !ok {
+		return company, NewAPIError(http.StatusForbidden, "company_access_denied")
+	}
+	return company, nil
+}
+
+// findUserSample находит заявку пользователя по шаблону sampleId для компании companyId. Заявка, ещё не
+// закреплённая ни за одной компанией, закрепляется за companyId при первом обращении
+func findUserSample(db *gorm.DB, userId int, companyId int, sampleId int) (config.UserSample, error) {
+	var userSample config.UserSample
+
+	find := func() *gorm.DB {
+		linked := db.Model(&config.UserSampleCompany{}).Select("user_sample_id").Where("company_id = ?", companyId)
+		return db.Where(config.UserSample{UserID: userId, SampleID: sampleId}).
+			Where("id IN (?)", linked).
+			Limit(1).Find(&userSample)
+	}
+
+	res := find()
+	if res.Error != nil || res.RowsAffected != 0 {
+		return userSample, res.Error
+	}
+
+	res = db.Where(config.UserSample{UserID: userId, SampleID: sampleId}).
+		Where("id NOT IN (?)", db.Model(&config.UserSampleCompany{}).Select("user_sample_id")).
+		Limit(1).Find(&userSample)
+	if res.Error != nil {
+		return userSample, res.Error
+	} else if res.RowsAffected == 0 {
+		return userSample, gorm.ErrRecordNotFound
+	}
+
+	// До закрепления файлы заявки лежали в каталоге образца — переносим их в каталог заявки
+	if err := moveLegacyUserSampleFiles(userSample); err != nil {
+		return userSample, err
+	}
+
+	link := config.UserSampleCompany{UserSampleID: userSample.ID, CompanyID: companyId}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&link).Error; err != nil {
+		return userSample, err
+	}
+	// Заявку могли одновременно закрепить за другой компанией
+	res = find()
+	if res.Error == nil && res.RowsAffected == 0 {
+		return userSample, gorm.ErrRecordNotFound
+	}
+	return userSample, res.Error
+}
+
+// userSampleDir — каталог файлов заявки в хранилище. Путь строится по ID заявки: у консультанта по одному
+// образцу бывает несколько заявок разных компаний
+func userSampleDir(userSample config.UserSample) string {
+	return fmt.Sprintf("users/%d/userSamples/%d/", userSample.UserID, userSample.ID)
+}
+
+// legacyUserSampleDir — каталог, в котором файлы заявки хранились до привязки заявок к компаниям
+func legacyUserSampleDir(userId int, sampleId string) string {
+	return fmt.Sprintf("users/%d/samples/%s/", userId, sampleId)
+}
+
+// moveLegacyUserSampleFiles переносит файлы заявки из legacyUserSampleDir в userSampleDir. Прерванный
+// перенос безопасно повторить: объекты перезаписываются, а из старого каталога удаляются последними
+func moveLegacyUserSampleFiles(userSample config.UserSample) error {
+	storage := config.Storage()
+	ctx := context.Background()
+	from := legacyUserSampleDir(userSample.UserID, strconv.Itoa(userSample.SampleID))
+
+	objects, err := storage.ListObjects(ctx, from, true)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		obj, err := storage.GetObject(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(obj)
+		obj.Close()
+		if err != nil {
+			return err
+		}
+
+		key := userSampleDir(userSample) + strings.TrimPrefix(object.Key, from)
+		if err := storage.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)), object.ContentType); err != nil {
+			return err
+		}
+	}
+
+	for _, object := range objects {
+		if err := storage.RemoveObject(ctx, object.Key); err != nil && !errors.Is(err, config.ErrObjectNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// companyUserIds — пользователи, связанные с компанией, включая тех, у кого она указана в User.CompanyINN
+func companyUserIds(company config.Company) []int {
+	db := config.DB()
+
+	var ids []int
+	db.Model(&config.UserCompany{}).Where(config.UserCompany{CompanyID: company.ID}).Pluck("user_id", &ids)
+
+	var legacyIds []int
+	db.Model(&config.User{}).Where(config.User{CompanyINN: company.INN}).Pluck("id", &legacyIds)
+	for _, id := range legacyIds {
+		if !containsInt(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// canManageCompanyUsers — связями компании управляет её владелец или пользователь с правом userCompany:manage
+func canManageCompanyUsers(user config.User, company config.Company) bool {
+	if userCan(user, config.PermUserCompaniesManage) {
+		return true
+	}
+	role, ok := userCompanyRole(user, company)
+	return ok && role == config.UserCompanyOwner
+}
+
+type userCompanyItem struct {
+	CompanyID int       `json:"companyId"`
+	INN       string    `json:"inn"`
+	OGRN      string    `json:"ogrn"`
+	Role      string    `json:"role"`
+	Primary   bool      `json:"primary"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func ListUserCompanies(c echo.Context) error {
+	db := config.DB()
+
+	user := contextUser(c)
+	if userIdHeader := c.Request().Header.Get("userId"); userIdHeader != "" {
+		userId, err := strconv.Atoi(userIdHeader)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "userId"})
+		}
+		if userId != user.ID {
+			if !userCan(user, config.PermUserCompaniesManage) {
+				return permissionDenied(user)
+			}
+			if err := db.First(&user, userId).Error; err != nil {
+				return NewAPIError(http.StatusNotFound, "user_not_found")
+			}
+		}
+	}
+
+	// Основная компания без связи ещё не попала в UserCompany — userCompanyRole её создаст
+	if user.CompanyINN != "" {
+		var company config.Company
+		res := db.Where(config.Company{INN: user.CompanyINN}).Limit(1).Find(&company)
+		if res.Error == nil && res.RowsAffected != 0 {
+			userCompanyRole(user, company)
+		}
+	}
+
+	var links []config.UserCompany
+	if err := db.Where(config.UserCompany{UserID: user.ID}).Order("id").Find(&links).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	companyIds := make([]int, 0, len(links))
+	for _, link := range links {
+		companyIds = append(companyIds, link.CompanyID)
+	}
+	var companies []config.Company
+	if len(companyIds) > 0 {
+		if err := db.Select("id", "inn", "ogrn").Where("id IN ?", companyIds).Find(&companies).Error; err != nil {
+			return wrapError(http.StatusInternalServerError, "database_error", err)
+		}
+	}
+	companiesById := make(map[int]config.Company, len(companies))
+	for _, company := range companies {
+		companiesById[company.ID] = company
+	}
+
+	items := make([]userCompanyItem, 0, len(links))
+	for _, link := range links {
+		company := companiesById[link.CompanyID]
+		items = append(items, userCompanyItem{
+			CompanyID: link.CompanyID,
+			INN:       company.INN,
+			OGRN:      company.OGRN,
+			Role:      link.Role,
+			Primary:   company.INN != "" && company.INN == user.CompanyINN,
+			CreatedAt: link.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
+
+// LinkUserCompany даёт пользователю с почтой email доступ к компании companyINN с ролью role
+func LinkUserCompany(c echo.Context) error {
+	db := config.DB()
+
+	inn := c.Request().Header.Get("companyINN")
+	email := c.Request().Header.Get("email")
+	role := c.Request().Header.Get("role")
+	if role == "" {
+		role = config.UserCompanyConsultant
+	}
+	if inn == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "companyINN"})
+	} else if email == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "email"})
+	} else if !config.IsUserCompanyRole(role) {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "role"})
+	}
+
+	var company config.Company
+	res := db.Where(config.Company{INN: inn}).Limit(1).Find(&company)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusForbidden, "company_not_found")
+	}
+
+	user := contextUser(c)
+	if !canManageCompanyUsers(user, company) {
+		return permissionDenied(user)
+	}
+
+	var target config.User
+	res = db.Where(config.User{Email: email}).Limit(1).Find(&target)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "user_not_found")
+	}
+
+	link := config.UserCompany{UserID: target.ID, CompanyID: company.ID, Role: role}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "company_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role"}),
+	}).Create(&link).Error
+	if err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	AddLog(user.ID, "Link UserCompany", strconv.Itoa(target.ID)+" → "+inn+" ("+role+")")
+	return c.JSON(http.StatusOK, link)
+}
+
+// UnlinkUserCompany отзывает доступ к компании companyINN; без userId пользователь отказывается от доступа сам
+func UnlinkUserCompany(c echo.Context) error {
+	db := config.DB()
+
+	inn := c.Request().Header.Get("companyINN")
+	if inn == "" {
+		return NewAPIError(http.StatusBadRequest, "field_missing").WithDetails(map[string]string{"field": "companyINN"})
+	}
+
+	user := contextUser(c)
+	targetId := user.ID
+	if userIdHeader := c.Request().Header.Get("userId"); userIdHeader != "" {
+		userId, err := strconv.Atoi(userIdHeader)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "userId"})
+		}
+		targetId = userId
+	}
+
+	var company config.Company
+	res := db.Where(config.Company{INN: inn}).Limit(1).Find(&company)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return NewAPIError(http.StatusForbidden, "company_not_found")
+	}
+
+	if targetId != user.ID && !canManageCompanyUsers(user, company) {
+		return permissionDenied(user)
+	}
+
+	// Основная компания пользователя задана в User.CompanyINN — связь с ней восстановилась бы сама
+	var target config.User
+	if err := db.First(&target, targetId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "user_not_found")
+	} else if target.CompanyINN == inn {
+		return NewAPIError(http.StatusConflict, "primary_company")
+	}
+
+	res = db.Where(config.UserCompany{UserID: targetId, CompanyID: company.ID}).Delete(&config.UserCompany{})
+	if res.Error != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", res.Error)
+	} else if res.RowsAffected == 0 {
+		return NewAPIError(http.StatusNotFound, "not_found")
+	}
+	// Без доступа к компании уведомления о её грантах приходить не должны
+	db.Where(config.GrantAlertSubscription{UserID: targetId, CompanyID: company.ID}).Delete(&config.GrantAlertSubscription{})
+
+	AddLog(user.ID, "Unlink UserCompany", strconv.Itoa(targetId)+" → "+inn)
+	return c.JSON(http.StatusOK, nil)
+}
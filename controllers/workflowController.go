@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"park/config"
+	"strconv"
+)
+
+// SampleStatus — этап заполнения заявки пользователем (UserSample.Status)
+type SampleStatus string
+
+const (
+	// StatusUpload — начальный этап: пользователь загружает документы. Любой неизвестный статус считается им.
+	StatusUpload  SampleStatus = "upload"
+	StatusStartAI SampleStatus = "startAI"
+	StatusAwaitAI SampleStatus = "awaitAI"
+	// StatusFailedAI — извлечение полей ИИ не удалось; причина — в задаче (GetAIJobStatus) и в истории переходов
+	StatusFailedAI SampleStatus = "failedAI"
+	StatusDoneAI   SampleStatus = "doneAI"
+	StatusFilling  SampleStatus = "filling"
+	StatusFilled   SampleStatus = "filled"
+	StatusSigned   SampleStatus = "signed"
+	StatusSent     SampleStatus = "sent"
+)
+
+// Порядок этапов — используется для отката ("rewind") только назад
+var sampleStatusOrder = []SampleStatus{
+	StatusUpload, StatusStartAI, StatusAwaitAI, StatusFailedAI, StatusDoneAI, StatusFilling, StatusFilled, StatusSigned, StatusSent,
+}
+
+var sampleTransitions = map[SampleStatus][]SampleStatus{
+	StatusUpload:   {StatusStartAI},
+	StatusStartAI:  {StatusStartAI, StatusAwaitAI},
+	StatusAwaitAI:  {StatusDoneAI, StatusFailedAI, StatusStartAI},
+	StatusFailedAI: {StatusAwaitAI},
+	StatusDoneAI:   {StatusFilling},
+	StatusFilling:  {StatusFilling, StatusFilled},
+	StatusFilled:   {StatusSigned},
+	StatusSigned:   {StatusSent},
+}
+
+// WorkflowError — недопустимый этап заявки для запрошенного действия
+type WorkflowError struct {
+	From     SampleStatus   `json:"from"`
+	To       SampleStatus   `json:"to,omitempty"`
+	Expected []SampleStatus `json:"expected,omitempty"`
+}
+
+func (e *WorkflowError) Error() string {
+	if e.To != "" {
+		return "invalid step: " + string(e.From) + " -> " + string(e.To)
+	}
+	return "invalid step: " + string(e.From)
+}
+
+func sampleStatusOf(userSample config.UserSample) SampleStatus {
+	status := SampleStatus(userSample.Status)
+	if _, ok := sampleStatusIndex(status); !ok {
+		return StatusUpload
+	}
+	return status
+}
+
+func sampleStatusIndex(status SampleStatus) (int, bool) {
+	for i, s := range sampleStatusOrder {
+		if s == status {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func canTransition(from SampleStatus, to SampleStatus) bool {
+	for _, allowed := range sampleTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// requireSampleStatus проверяет, что заявка находится на одном из этапов expected
+func requireSampleStatus(userSample config.UserSample, expected ...SampleStatus) error {
+	current := sampleStatusOf(userSample)
+	for _, status := range expected {
+		if current == status {
+			return nil
+		}
+	}
+	return &WorkflowError{From: current, Expected: expected}
+}
+
+// transitionUserSample переводит заявку на этап to, если переход разрешён, и пишет его в историю; actorId 0 — система
+func transitionUserSample(db *gorm.DB, userSample *config.UserSample, to SampleStatus, actorId int, reason string) error {
+	from := sampleStatusOf(*userSample)
+	if !canTransition(from, to) {
+		return &WorkflowError{From: from, To: to}
+	}
+	return applySampleTransition(db, userSample, to, actorId, reason, false)
+}
+
+func applySampleTransition(db *gorm.DB, userSample *config.UserSample, to SampleStatus, actorId int, reason string, rewind bool) error {
+	from := userSample.Status
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Условие по старому статусу защищает от параллельной смены этапа другим запросом или обработчиком
+		res := tx.Model(&config.UserSample{}).
+			Where("id = ? AND status = ?", userSample.ID, from).
+			Update("status", string(to))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			var current config.UserSample
+			tx.First(&current, userSample.ID)
+			return &WorkflowError{From: sampleStatusOf(current), To: to}
+		}
+
+		transition := config.UserSampleTransition{
+			UserSampleID: userSample.ID,
+			FromStatus:   from,
+			ToStatus:     string(to),
+			ActorID:      actorId,
+			Reason:       reason,
+			Rewind:       rewind,
+		}
+		if err := tx.Create(&transition).Error; err != nil {
+			return err
+		}
+
+		userSample.Status = string(to)
+		return nil
+	})
+}
+
+func RewindUserSample(c echo.Context) error {
+	db := config.DB()
+
+	userSampleId, err := strconv.Atoi(c.Request().Header.Get("userSampleId"))
+	if err != nil {
+		return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "userSampleId"})
+	}
+	status := SampleStatus(c.Request().Header.Get("status"))
+	reason := c.Request().Header.Get("reason")
+
+	// Право userSample:rewind проверяется RequirePermission на маршруте
+	user := contextUser(c)
+
+	var userSample config.UserSample
+	if err := db.First(&userSample, userSampleId).Error; err != nil {
+		return NewAPIError(http.StatusNotFound, "user_sample_not_found")
+	}
+
+	// Откат возможен только на более ранний этап; awaitAI и failedAI без задачи смысла не имеют
+	targetIndex, ok := sampleStatusIndex(status)
+	currentIndex, _ := sampleStatusIndex(sampleStatusOf(userSample))
+	if !ok || status == StatusAwaitAI || status == StatusFailedAI || targetIndex >= currentIndex {
+		return NewAPIError(http.StatusBadRequest, "invalid_step").WithDetails(&WorkflowError{From: sampleStatusOf(userSample), To: status})
+	}
+
+	if err := applySampleTransition(db, &userSample, status, user.ID, reason, true); err != nil {
+		return wrapError(http.StatusConflict, "invalid_step", err)
+	}
+
+	AddLog(user.ID, "Rewind UserSample", strconv.Itoa(userSample.ID)+": "+string(status))
+
+	return c.JSON(http.StatusOK, userSample)
+}
+
+func GetUserSampleHistory(c echo.Context) error {
+	db := config.DB()
+
+	sampleId := c.Request().Header.Get("sampleId")
+	userSampleId := c.Request().Header.Get("userSampleId")
+
+	user := contextUser(c)
+
+	var userSample config.UserSample
+	if userSampleId != "" {
+		// Историю чужих заявок видят только пользователи с правом userSample:read
+		if !userCan(user, config.PermUserSampleRead) {
+			return permissionDenied(user)
+		}
+		userSampleIdInt, err := strconv.Atoi(userSampleId)
+		if err != nil {
+			return NewAPIError(http.StatusBadRequest, "invalid_params").WithDetails(map[string]string{"field": "userSampleId"})
+		}
+		if err := db.First(&userSample, userSampleIdInt).Error; err != nil {
+			return NewAPIError(http.StatusNotFound, "user_sample_not_found")
+		}
+	} else {
+		company, err := activeCompany(c)
+		if err != nil {
+			return err
+		}
+		sampleIdInt, _ := strconv.Atoi(sampleId)
+		userSample, err = findUserSample(db, user.ID, company.ID, sampleIdInt)
+		if err != nil {
+			return NewAPIError(http.StatusNotFound, "user_sample_not_found")
+		}
+	}
+
+	var history []config.UserSampleTransition
+	if err := db.Where(config.UserSampleTransition{UserSampleID: userSample.ID}).Order("id").Find(&history).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  sampleStatusOf(userSample),
+		"history": history,
+	})
+}
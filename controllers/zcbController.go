@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"park/config"
+	"time"
+)
+
+var zcbEndpointURLs = map[string]string{
+	config.ZCBEndpointCard: API_URL_CARD,
+	config.ZCBEndpointFssp: API_URL_FSSP,
+	config.ZCBEndpointFns:  API_URL_FNS,
+}
+
+var zcbEndpointColumns = map[string]string{
+	config.ZCBEndpointCard: "card_data",
+	config.ZCBEndpointFssp: "fssp_data",
+	config.ZCBEndpointFns:  "fns_data",
+}
+
+// companyDataFetcher запрашивает данные у источника: через очередь ZCB или, внутри задачи этой очереди, напрямую
+type companyDataFetcher func(key string, endpoint string) ([]byte, error)
+
+// fetchCompanyData возвращает ответ ZCB по запросу endpoint для компании inn: из CompanyDataCache, если он
+// свежее ZCBCacheTTL, иначе запрашивает ZCB. key — ИНН для card, ОГРН для fssp и fns. Ответ кешируется
+// для любого ИНН, а у зарегистрированной компании ещё и обновляет Company
+func fetchCompanyData(inn string, key string, endpoint string, force bool) ([]byte, error) {
+	return loadCompanyData(inn, key, endpoint, force, ZCBSendToQueue)
+}
+
+func loadCompanyData(inn string, key string, endpoint string, force bool, fetch companyDataFetcher) ([]byte, error) {
+	db := config.DB()
+
+	if !force {
+		var cached config.CompanyDataCache
+		res := db.Where(config.CompanyDataCache{INN: inn, Endpoint: endpoint}).Limit(1).Find(&cached)
+		if res.Error == nil && res.RowsAffected != 0 && len(cached.Data) > 0 &&
+			time.Since(cached.FetchedAt) < config.ZCBCacheTTL(endpoint) {
+			return cached.Data, nil
+		}
+	}
+
+	data, err := fetch(key, endpoint)
+	if err != nil {
+		return data, err
+	}
+
+	cached := config.CompanyDataCache{INN: inn, Endpoint: endpoint, Data: data, FetchedAt: time.Now()}
+	db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "inn"}, {Name: "endpoint"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "fetched_at"}),
+	}).Create(&cached)
+
+	var company config.Company
+	res := db.Where(config.Company{INN: inn}).Limit(1).Find(&company)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return data, nil
+	}
+
+	db.Transaction(func(tx *gorm.DB) error {
+		if endpoint == config.ZCBEndpointCard {
+			if err := recordCompanyChanges(tx, company.ID, company.CardData, data); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&company).Update(zcbEndpointColumns[endpoint], json.RawMessage(data)).Error
+	})
+	return data, nil
+}
+
+// countZCBCall учитывает платный запрос к ZCB в счётчике за сегодня
+func countZCBCall(endpoint string) {
+	usage := config.ZCBUsage{Day: time.Now().Format("2006-01-02"), Endpoint: endpoint, Calls: 1}
+	config.DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "day"}, {Name: "endpoint"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"calls": gorm.Expr("zcb_usages.calls + 1")}),
+	}).Create(&usage)
+}
+
+// ListZCBUsage — платные запросы к ZCB по дням за последние 30 дней
+func ListZCBUsage(c echo.Context) error {
+	db := config.DB()
+
+	since := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+
+	var usage []config.ZCBUsage
+	if err := db.Where("day >= ?", since).Order("day desc, endpoint").Find(&usage).Error; err != nil {
+		return wrapError(http.StatusInternalServerError, "database_error", err)
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
@@ -12,6 +12,8 @@ const keyValidality = 14
 
 func main() {
 	e := echo.New()
+	e.HTTPErrorHandler = controllers.HTTPErrorHandler
+	e.IPExtractor = controllers.ClientIPExtractor()
 
 	config.DatabaseInit()
 	gorm := config.DB()
@@ -29,7 +31,18 @@ func main() {
 		panic(err)
 	}
 
-	config.InitMinio()
+	config.InitStorage()
+	controllers.InitDecreeIndex()
+	controllers.InitGrantSearch()
+	controllers.InitCompanyDataProvider()
+	controllers.InitRateLimitStore()
+
+	controllers.StartQueues()
+	controllers.StartTokenSweeper()
+	controllers.StartCompanyReverification()
+	controllers.StartRateLimitSweeper()
+	controllers.StartGrantScheduler()
+	controllers.StartGrantAlertDigest()
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     []string{"https://fintechnik.online"},
@@ -38,7 +51,12 @@ func main() {
 		AllowCredentials: true,
 	}))
 
+	e.Use(controllers.AuthMiddleware)
+	e.Use(controllers.QuotaMiddleware)
+
 	controllers.AddRoutes(e)
+	controllers.AddServiceRoutes(e)
+	controllers.AddAPIRoutes(e)
 
 	e.Logger.Fatal(e.Start(":8080"))
 